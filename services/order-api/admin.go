@@ -0,0 +1,42 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// adminEnabled turns on the admin endpoints and import options meant for
+// test and migration environments; it is set from ENABLE_ADMIN.
+var adminEnabled bool
+
+// adminResetHandler puts the store back to its startup seed: the sample
+// orders or SEED_FILE when seedData is set, otherwise empty. It is only
+// registered when ENABLE_ADMIN is true, for resetting between integration
+// tests without a restart.
+func adminResetHandler(s *memoryStore, seedData bool, seedFile string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.Method != "POST" {
+			writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+			return
+		}
+
+		if seedData {
+			if err := initOrders(s, seedFile); err != nil {
+				writeError(w, http.StatusInternalServerError, "Failed to reseed orders: "+err.Error())
+				return
+			}
+		} else {
+			s.seed(nil)
+		}
+		idempotencyKeys.clear()
+
+		s.mu.Lock()
+		s.changed()
+		count := len(s.orders)
+		s.mu.Unlock()
+
+		logEvent(r, "warn", "Store reset", "orders", count)
+		json.NewEncoder(w).Encode(Response{Success: true, Count: counted(count)})
+	}
+}
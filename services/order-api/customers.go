@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// customerAPIURL, when set from CUSTOMER_API_URL, is the customer service
+// that new orders' customers are checked against; GET {url}/{id} must answer
+// 200 for a customer that exists and 404 for one that doesn't.
+var (
+	customerAPIURL string
+	customerClient = &http.Client{Timeout: 2 * time.Second}
+)
+
+// checkCustomer rejects orders for customers the customer service doesn't
+// know, and fails closed when the service can't be asked.
+func checkCustomer(ctx context.Context, customerID int) error {
+	if customerAPIURL == "" {
+		return nil
+	}
+	url := strings.TrimSuffix(customerAPIURL, "/") + "/" + strconv.Itoa(customerID)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := customerClient.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		logEvent(nil, "error", "Customer service unreachable", "customer_id", customerID, "error", err.Error())
+		return &statusError{http.StatusServiceUnavailable, "Customer service is unavailable"}
+	}
+	resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return &statusError{http.StatusUnprocessableEntity, fmt.Sprintf("Unknown customer %d", customerID)}
+	case resp.StatusCode < 200 || resp.StatusCode >= 300:
+		logEvent(nil, "error", "Customer service returned an error", "customer_id", customerID, "status", resp.StatusCode)
+		return &statusError{http.StatusServiceUnavailable, "Customer service is unavailable"}
+	}
+	return nil
+}
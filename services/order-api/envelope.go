@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// envelopeWriter buffers a response so the Response envelope can be
+// stripped once the handler is done.
+type envelopeWriter struct {
+	http.ResponseWriter
+	status int
+	buf    bytes.Buffer
+}
+
+func (e *envelopeWriter) WriteHeader(code int) {
+	if e.status == 0 {
+		e.status = code
+	}
+}
+
+func (e *envelopeWriter) Write(b []byte) (int, error) {
+	return e.buf.Write(b)
+}
+
+// finish replaces an enveloped body with its data, or with just the error,
+// code and any violations on failure, leaving the status code to say which it was. Counts
+// move to the X-Total-Count and X-Returned-Count headers. Bodies that aren't
+// a Response, like /metrics in JSON, go out untouched.
+func (e *envelopeWriter) finish() {
+	body := e.buf.Bytes()
+	if strings.HasPrefix(e.Header().Get("Content-Type"), "application/json") && len(body) > 0 {
+		var env struct {
+			Success    *bool           `json:"success"`
+			Data       json.RawMessage `json:"data"`
+			Error      string          `json:"error"`
+			Code       string          `json:"code"`
+			Violations json.RawMessage `json:"violations"`
+			Count      *int            `json:"count"`
+			Returned   *int            `json:"returned"`
+		}
+		if err := json.Unmarshal(body, &env); err == nil && env.Success != nil {
+			if env.Count != nil {
+				e.Header().Set("X-Total-Count", strconv.Itoa(*env.Count))
+			}
+			if env.Returned != nil {
+				e.Header().Set("X-Returned-Count", strconv.Itoa(*env.Returned))
+			}
+			switch {
+			case !*env.Success:
+				body, _ = json.Marshal(struct {
+					Error      string          `json:"error"`
+					Code       string          `json:"code"`
+					Violations json.RawMessage `json:"violations,omitempty"`
+				}{env.Error, env.Code, env.Violations})
+			case env.Data != nil:
+				body = env.Data
+			default:
+				body = []byte("null")
+			}
+			body = append(body, '\n')
+			e.Header().Set("Content-Length", strconv.Itoa(len(body)))
+		}
+	}
+	if e.status != 0 {
+		e.ResponseWriter.WriteHeader(e.status)
+	}
+	e.ResponseWriter.Write(body)
+}
+
+// stripEnvelope serves bare JSON bodies when the request has
+// ?envelope=false, for generic tooling that doesn't know our wrapper.
+func stripEnvelope(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if v, err := strconv.ParseBool(r.URL.Query().Get("envelope")); err != nil || v {
+			next.ServeHTTP(w, r)
+			return
+		}
+		// HEAD is served as GET so Content-Length is computed from the
+		// stripped body; the server drops the body itself.
+		if r.Method == http.MethodHead {
+			r = r.Clone(r.Context())
+			r.Method = http.MethodGet
+		}
+		ew := &envelopeWriter{ResponseWriter: w}
+		next.ServeHTTP(ew, r)
+		ew.finish()
+	})
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"time"
+)
+
+// ordersExpiredTotal counts pending orders moved to expired by the sweeper.
+var ordersExpiredTotal uint64
+
+// errNotExpirable marks an order that progressed or was deleted between the
+// sweep's scan and its update.
+var errNotExpirable = errors.New("order is no longer expirable")
+
+// isExpirable reports whether o has sat in pending since before cutoff.
+func isExpirable(o *Order, cutoff time.Time) bool {
+	return o.Status == statusPending && o.DeletedAt == nil && o.CreatedAt.Before(cutoff)
+}
+
+// sweepExpired runs expirePending every interval until ctx is done, then
+// closes done so shutdown can wait for an in-progress sweep to finish.
+func sweepExpired(ctx context.Context, s OrderStore, ttl, interval time.Duration, done chan<- struct{}) {
+	defer close(done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := expirePending(ctx, s, ttl); err != nil && ctx.Err() == nil {
+				logEvent(nil, "error", "Failed to expire pending orders", "error", err.Error())
+			}
+		}
+	}
+}
+
+// expirePending moves every pending order created more than ttl ago to
+// expired, recording the change in its history, and returns how many it
+// expired. All of them are written in one store update.
+func expirePending(ctx context.Context, s OrderStore, ttl time.Duration) (int, error) {
+	cutoff := now().Add(-ttl)
+	stale, err := s.Find(ctx, func(o *Order) bool { return isExpirable(o, cutoff) })
+	if err != nil || len(stale) == 0 {
+		return 0, err
+	}
+	ids := make([]int, len(stale))
+	for i, o := range stale {
+		ids[i] = o.ID
+	}
+
+	updated, errs, err := s.UpdateMany(ctx, ids, func(o *Order) error {
+		if !isExpirable(o, cutoff) {
+			return errNotExpirable
+		}
+		o.Status = statusExpired
+		o.History = append(o.History, OrderEvent{
+			Timestamp: now(),
+			From:      statusPending,
+			To:        statusExpired,
+			Actor:     "system",
+		})
+		o.Version++
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	expired := 0
+	for i, o := range updated {
+		if errs[i] != nil {
+			continue
+		}
+		expired++
+		notifyWebhook(eventOrderExpired, o)
+		atomic.AddUint64(&ordersExpiredTotal, 1)
+	}
+	if expired > 0 {
+		logEvent(nil, "info", "Expired stale pending orders", "count", expired, "ttl", ttl.String())
+	}
+	return expired, nil
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"net/http"
+	"strings"
+)
+
+const gzipMinSize = 1024
+
+// gzipWriter buffers the start of a response so that only bodies of at least
+// gzipMinSize bytes are compressed; small ones like /health go out as-is.
+type gzipWriter struct {
+	http.ResponseWriter
+	status  int
+	buf     bytes.Buffer
+	gz      *gzip.Writer
+	decided bool
+}
+
+func (g *gzipWriter) WriteHeader(code int) {
+	if g.status == 0 {
+		g.status = code
+	}
+}
+
+func (g *gzipWriter) Write(p []byte) (int, error) {
+	if g.gz != nil {
+		return g.gz.Write(p)
+	}
+	if g.decided {
+		return g.ResponseWriter.Write(p)
+	}
+	g.buf.Write(p)
+	if g.buf.Len() >= gzipMinSize {
+		if err := g.decide(); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+func (g *gzipWriter) decide() error {
+	g.decided = true
+	h := g.Header()
+	if g.buf.Len() >= gzipMinSize && h.Get("Content-Encoding") == "" && compressible(h.Get("Content-Type")) {
+		h.Set("Content-Encoding", "gzip")
+		h.Del("Content-Length")
+		g.gz = gzip.NewWriter(g.ResponseWriter)
+	}
+	if g.status != 0 {
+		g.ResponseWriter.WriteHeader(g.status)
+	}
+	if g.buf.Len() == 0 {
+		return nil
+	}
+	var err error
+	if g.gz != nil {
+		_, err = g.gz.Write(g.buf.Bytes())
+	} else {
+		_, err = g.ResponseWriter.Write(g.buf.Bytes())
+	}
+	g.buf.Reset()
+	return err
+}
+
+func (g *gzipWriter) finish() {
+	if !g.decided {
+		g.decide()
+	}
+	if g.gz != nil {
+		g.gz.Close()
+	}
+}
+
+func compressible(contentType string) bool {
+	return strings.HasPrefix(contentType, "application/json") || strings.HasPrefix(contentType, "text/")
+}
+
+func gzipResponses(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+		if r.Method == http.MethodHead || !acceptsGzip(r.Header.Get("Accept-Encoding")) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		gw := &gzipWriter{ResponseWriter: w}
+		defer gw.finish()
+		next.ServeHTTP(gw, r)
+	})
+}
+
+func acceptsGzip(header string) bool {
+	for _, enc := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(enc), ";")
+		if strings.TrimSpace(name) == "gzip" && strings.ReplaceAll(params, " ", "") != "q=0" {
+			return true
+		}
+	}
+	return false
+}
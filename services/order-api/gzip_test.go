@@ -0,0 +1,54 @@
+package main
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func jsonHandler(body string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, body)
+	})
+}
+
+func TestGzipRoundTrip(t *testing.T) {
+	body := `{"success":true,"data":[` + strings.Repeat(`{"id":1,"status":"pending"},`, 100) + `{"id":2}]}`
+	req := httptest.NewRequest("GET", "/api/orders", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	rec := httptest.NewRecorder()
+	gzipResponses(jsonHandler(body)).ServeHTTP(rec, req)
+
+	if got := rec.Header().Get("Content-Encoding"); got != "gzip" {
+		t.Fatalf("Content-Encoding = %q, want gzip", got)
+	}
+	zr, err := gzip.NewReader(rec.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != body {
+		t.Fatalf("decompressed body differs:\ngot  %s\nwant %s", got, body)
+	}
+}
+
+func TestGzipSkipsSmallResponses(t *testing.T) {
+	req := httptest.NewRequest("GET", "/health", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	rec := httptest.NewRecorder()
+	gzipResponses(jsonHandler(`{"status":"healthy"}`)).ServeHTTP(rec, req)
+
+	if got := rec.Header().Get("Content-Encoding"); got != "" {
+		t.Fatalf("Content-Encoding = %q, want none", got)
+	}
+	if got := rec.Body.String(); got != `{"status":"healthy"}` {
+		t.Fatalf("body = %s", got)
+	}
+}
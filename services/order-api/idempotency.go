@@ -0,0 +1,94 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+const idempotencyTTL = 24 * time.Hour
+
+type idempotencyEntry struct {
+	order   Order
+	expires time.Time
+
+	// pending is closed when the create claiming the key finishes; nil once
+	// the entry holds its order.
+	pending chan struct{}
+}
+
+type idempotencyCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	entries map[string]idempotencyEntry
+}
+
+var idempotencyKeys = &idempotencyCache{
+	ttl:     idempotencyTTL,
+	entries: make(map[string]idempotencyEntry),
+}
+
+// once returns the order previously created under key, or calls create and
+// remembers its result. A call claims the key with a pending entry before
+// create runs, outside the cache lock, so concurrent retries with the same key
+// wait for it rather than creating a second order, and other keys don't wait
+// at all. A failed create releases the key for the next caller to try.
+func (c *idempotencyCache) once(key string, create func() (Order, error)) (Order, bool, error) {
+	c.mu.Lock()
+	for {
+		e, ok := c.entries[key]
+		if !ok || (e.pending == nil && !time.Now().Before(e.expires)) {
+			break
+		}
+		if e.pending == nil {
+			c.mu.Unlock()
+			return e.order, true, nil
+		}
+		c.mu.Unlock()
+		<-e.pending
+		c.mu.Lock()
+	}
+	pending := make(chan struct{})
+	c.entries[key] = idempotencyEntry{pending: pending}
+	c.mu.Unlock()
+
+	created := false
+	var order Order
+	defer func() {
+		c.mu.Lock()
+		if e, ok := c.entries[key]; ok && e.pending == pending {
+			if created {
+				c.entries[key] = idempotencyEntry{order: order, expires: time.Now().Add(c.ttl)}
+			} else {
+				delete(c.entries, key)
+			}
+		}
+		c.mu.Unlock()
+		close(pending)
+	}()
+	order, err := create()
+	if err != nil {
+		return Order{}, false, err
+	}
+	created = true
+	return order, false, nil
+}
+
+// clear forgets every key, for when the orders they point at are gone.
+func (c *idempotencyCache) clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries = make(map[string]idempotencyEntry)
+}
+
+func (c *idempotencyCache) cleanup(interval time.Duration) {
+	for range time.Tick(interval) {
+		now := time.Now()
+		c.mu.Lock()
+		for key, e := range c.entries {
+			if e.pending == nil && now.After(e.expires) {
+				delete(c.entries, key)
+			}
+		}
+		c.mu.Unlock()
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newTestIdempotencyCache() *idempotencyCache {
+	return &idempotencyCache{ttl: time.Hour, entries: make(map[string]idempotencyEntry)}
+}
+
+func TestIdempotencySameKeyCreatesOnce(t *testing.T) {
+	c := newTestIdempotencyCache()
+	var calls atomic.Int32
+	release := make(chan struct{})
+	create := func() (Order, error) {
+		calls.Add(1)
+		<-release
+		return Order{ID: 1}, nil
+	}
+
+	var wg sync.WaitGroup
+	var replays atomic.Int32
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			o, replayed, err := c.once("k", create)
+			if err != nil || o.ID != 1 {
+				t.Errorf("once = %+v, %v", o, err)
+			}
+			if replayed {
+				replays.Add(1)
+			}
+		}()
+	}
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if calls.Load() != 1 || replays.Load() != 9 {
+		t.Fatalf("%d creates and %d replays, want 1 and 9", calls.Load(), replays.Load())
+	}
+}
+
+func TestIdempotencyOtherKeysDontWait(t *testing.T) {
+	c := newTestIdempotencyCache()
+	release := make(chan struct{})
+	defer close(release)
+	go c.once("slow", func() (Order, error) {
+		<-release
+		return Order{ID: 1}, nil
+	})
+	time.Sleep(20 * time.Millisecond)
+
+	done := make(chan struct{})
+	go func() {
+		c.once("fast", func() (Order, error) { return Order{ID: 2}, nil })
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("a create under another key waited on the slow one")
+	}
+}
+
+func TestIdempotencyFailedCreateReleasesKey(t *testing.T) {
+	c := newTestIdempotencyCache()
+	failed := errors.New("store down")
+	if _, _, err := c.once("k", func() (Order, error) { return Order{}, failed }); !errors.Is(err, failed) {
+		t.Fatalf("once = %v, want %v", err, failed)
+	}
+	o, replayed, err := c.once("k", func() (Order, error) { return Order{ID: 3}, nil })
+	if err != nil || replayed || o.ID != 3 {
+		t.Fatalf("retry after a failure = %+v, %t, %v", o, replayed, err)
+	}
+}
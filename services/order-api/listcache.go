@@ -0,0 +1,59 @@
+package main
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// listCacheMaxEntries bounds how many distinct queries are cached at once;
+// the cache starts over when it is reached.
+const listCacheMaxEntries = 256
+
+type listCacheEntry struct {
+	body     []byte
+	link     string
+	modified time.Time
+	expires  time.Time
+}
+
+// listCache holds serialized order list responses by query string. An entry
+// is only served while the store's modification time still matches the one
+// it was built at, so any write invalidates it, and for at most ttl.
+type listCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	entries map[string]listCacheEntry
+}
+
+// orderListCache is nil unless LIST_CACHE_TTL is set.
+var orderListCache *listCache
+
+var listCacheHits, listCacheMisses uint64
+
+func newListCache(ttl time.Duration) *listCache {
+	return &listCache{ttl: ttl, entries: make(map[string]listCacheEntry)}
+}
+
+func (c *listCache) get(key string, modified time.Time) (listCacheEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[key]
+	if !ok || !e.modified.Equal(modified) || !time.Now().Before(e.expires) {
+		atomic.AddUint64(&listCacheMisses, 1)
+		return listCacheEntry{}, false
+	}
+	atomic.AddUint64(&listCacheHits, 1)
+	return e, true
+}
+
+func (c *listCache) put(key string, modified time.Time, body []byte, link string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.entries) >= listCacheMaxEntries {
+		c.entries = make(map[string]listCacheEntry)
+	}
+	c.entries[key] = listCacheEntry{body: body, link: link, modified: modified, expires: time.Now().Add(c.ttl)}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+var (
+	jsonLogs   bool
+	jsonLogger = log.New(os.Stderr, "", 0)
+)
+
+// logLevels orders the levels logEvent accepts, lowest first.
+var logLevels = map[string]int{"debug": 0, "info": 1, "warn": 2, "error": 3}
+
+// minLogLevel is the least severe level logEvent writes, set from LOG_LEVEL.
+// Startup and shutdown lines use log.Printf directly and always appear.
+var minLogLevel = logLevels["info"]
+
+func configureLogging(format, level string) error {
+	jsonLogs = strings.EqualFold(format, "json")
+	n, ok := logLevels[strings.ToLower(level)]
+	if !ok {
+		return fmt.Errorf("unknown log level %q; valid: debug, info, warn, error", level)
+	}
+	minLogLevel = n
+	return nil
+}
+
+// logEvent writes one log line for msg with alternating key/value fields,
+// tagged with the request ID when r is non-nil. Lines below minLogLevel are
+// dropped.
+func logEvent(r *http.Request, level, msg string, kv ...interface{}) {
+	if logLevels[level] < minLogLevel {
+		return
+	}
+	var id string
+	if r != nil {
+		id = requestID(r)
+	}
+
+	if jsonLogs {
+		entry := map[string]interface{}{
+			"timestamp": time.Now().Format(time.RFC3339Nano),
+			"level":     level,
+			"msg":       msg,
+		}
+		if id != "" {
+			entry["request_id"] = id
+		}
+		for i := 0; i+1 < len(kv); i += 2 {
+			entry[fmt.Sprint(kv[i])] = kv[i+1]
+		}
+		line, err := json.Marshal(entry)
+		if err != nil {
+			log.Printf("Failed to encode log entry %q: %v", msg, err)
+			return
+		}
+		jsonLogger.Print(string(line))
+		return
+	}
+
+	var b strings.Builder
+	if id != "" {
+		b.WriteString("[" + id + "] ")
+	}
+	b.WriteString(msg)
+	for i := 0; i+1 < len(kv); i += 2 {
+		fmt.Fprintf(&b, " %v=%v", kv[i], kv[i+1])
+	}
+	log.Print(b.String())
+}
+
+// statusRecorder captures the status code and body size of a response for
+// the access log.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (s *statusRecorder) WriteHeader(code int) {
+	if s.status == 0 {
+		s.status = code
+	}
+	s.ResponseWriter.WriteHeader(code)
+}
+
+func (s *statusRecorder) Write(p []byte) (int, error) {
+	if s.status == 0 {
+		s.status = http.StatusOK
+	}
+	n, err := s.ResponseWriter.Write(p)
+	s.bytes += n
+	return n, err
+}
+
+// accessLog logs one line per request with its outcome. Paths in skip, by
+// default the probe endpoints, are not logged.
+func accessLog(skip map[string]bool, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if skip[r.URL.Path] {
+			next.ServeHTTP(w, r)
+			return
+		}
+		rec := &statusRecorder{ResponseWriter: w}
+		start := time.Now()
+		next.ServeHTTP(rec, r)
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		logEvent(r, "info", "Request handled",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", rec.status,
+			"bytes", rec.bytes,
+			"duration_ms", float64(time.Since(start).Microseconds())/1000,
+		)
+	})
+}
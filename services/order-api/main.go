@@ -2,252 +2,2097 @@
 package main
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"hash/fnv"
+	"io"
 	"log"
+	"math"
+	"mime"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
+	"os/signal"
+	"slices"
+	"sort"
 	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
+	"unicode/utf8"
 )
 
 type Order struct {
-	ID         int       `json:"id"`
-	CustomerID int       `json:"customer_id"`
-	ProductID  int       `json:"product_id"`
-	Quantity   int       `json:"quantity"`
-	Total      float64   `json:"total"`
-	Status     string    `json:"status"`
-	CreatedAt  time.Time `json:"created_at"`
+	ID          int        `json:"id"`
+	OrderNumber string     `json:"order_number,omitempty"`
+	CustomerID  int        `json:"customer_id"`
+	ProductID   int        `json:"product_id"`
+	Quantity    int        `json:"quantity"`
+	UnitPrice   Money      `json:"unit_price,omitempty"`
+	Items       []LineItem `json:"items,omitempty"`
+	Total       Money      `json:"total"`
+	Status      string     `json:"status"`
+	Version     int        `json:"version"`
+	CreatedAt   time.Time  `json:"created_at"`
+	Notes       string     `json:"notes,omitempty"`
+	Tags        []string   `json:"tags,omitempty"`
+	CancelledAt *time.Time `json:"cancelled_at,omitempty"`
+	DeletedAt   *time.Time `json:"deleted_at,omitempty"`
+
+	// ProcessingAt, ShippedAt and CompletedAt are when the order first
+	// entered each fulfillment status, stamped by recordChange.
+	ProcessingAt *time.Time `json:"processing_at,omitempty"`
+	ShippedAt    *time.Time `json:"shipped_at,omitempty"`
+	CompletedAt  *time.Time `json:"completed_at,omitempty"`
+
+	// History is served by /api/orders/{id}/history rather than inline.
+	History []OrderEvent `json:"-"`
+}
+
+// OrderEvent is one change in an order's audit history. From is empty for
+// the event recorded at creation and equals To when only the notes changed;
+// Notes is set to the new notes whenever they changed.
+type OrderEvent struct {
+	Timestamp time.Time `json:"timestamp"`
+	From      string    `json:"from,omitempty"`
+	To        string    `json:"to"`
+	Notes     *string   `json:"notes,omitempty"`
+	Actor     string    `json:"actor"`
+}
+
+type LineItem struct {
+	ProductID int   `json:"product_id"`
+	Quantity  int   `json:"quantity"`
+	UnitPrice Money `json:"unit_price"`
 }
 
 type Response struct {
-	Success bool        `json:"success"`
-	Data    interface{} `json:"data,omitempty"`
-	Error   string      `json:"error,omitempty"`
-	Count   int         `json:"count,omitempty"`
+	Success  bool        `json:"success"`
+	Data     interface{} `json:"data,omitempty"`
+	Error    string      `json:"error,omitempty"`
+	Code     string      `json:"code,omitempty"`
+	Count    *int        `json:"count,omitempty"`
+	Returned *int        `json:"returned,omitempty"`
+
+	// Violations lists everything wrong with a body that failed its schema.
+	Violations []schemaViolation `json:"violations,omitempty"`
+}
+
+// counted returns n for the list fields of Response, which are pointers so
+// that an empty list still reports a count of 0.
+func counted(n int) *int {
+	return &n
+}
+
+const (
+	defaultLimit = 50
+	maxLimit     = 500
+)
+
+var maxBodyBytes int64 = 1 << 20
+
+const defaultSort = "-created_at"
+
+var orderSorts = map[string]func(a, b *Order) bool{
+	"id":          func(a, b *Order) bool { return a.ID < b.ID },
+	"-id":         func(a, b *Order) bool { return a.ID > b.ID },
+	"created_at":  func(a, b *Order) bool { return a.CreatedAt.Before(b.CreatedAt) },
+	"-created_at": func(a, b *Order) bool { return a.CreatedAt.After(b.CreatedAt) },
+	"total":       func(a, b *Order) bool { return a.Total < b.Total },
+	"-total":      func(a, b *Order) bool { return a.Total > b.Total },
 }
 
 var (
-	orders      = make(map[int]*Order)
-	ordersMutex = &sync.RWMutex{}
-	nextID      = 1
-	startTime   = time.Now()
+	startTime = time.Now()
+
+	// now is the clock for order timestamps and Last-Modified; tests can
+	// swap it for a fixed one.
+	now = time.Now
+
+	storeReady   atomic.Bool
+	shuttingDown atomic.Bool
 )
 
 func main() {
-	initOrders()
+	if err := configureLogging(getEnv("LOG_FORMAT", "text"), getEnv("LOG_LEVEL", "info")); err != nil {
+		log.Fatalf("Invalid LOG_LEVEL: %v", err)
+	}
+	
+	initProducts()
+	
+	orderStore := newMemoryStore(os.Getenv("ORDERS_FILE"))
+	if raw := os.Getenv("MAX_ORDERS"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 0 {
+			log.Fatalf("Invalid MAX_ORDERS %q", raw)
+		}
+		orderStore.maxOrders = n
+	}
+	if raw := os.Getenv("MAX_QUANTITY"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 {
+			log.Fatalf("Invalid MAX_QUANTITY %q", raw)
+		}
+		maxQuantity = n
+	}
+	if raw := os.Getenv("MAX_ACTIVE_ORDERS_PER_CUSTOMER"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 0 {
+			log.Fatalf("Invalid MAX_ACTIVE_ORDERS_PER_CUSTOMER %q", raw)
+		}
+		orderStore.maxActivePerCustomer = n
+	}
+	switch strategy := getEnv("ID_STRATEGY", "sequential"); strategy {
+	case "sequential":
+	case "random":
+		orderStore.randomIDs = true
+	default:
+		log.Fatalf("Invalid ID_STRATEGY %q: must be sequential or random", strategy)
+	}
+	switch policy := getEnv("EVICTION_POLICY", "reject"); policy {
+	case "reject":
+	case "evict":
+		orderStore.evict = true
+	default:
+		log.Fatalf("Invalid EVICTION_POLICY %q: must be reject or evict", policy)
+	}
+	if err := setInitialStatus(getEnv("INITIAL_STATUS", statusPending)); err != nil {
+		log.Fatalf("Invalid INITIAL_STATUS: %v", err)
+	}
+	seedData, err := strconv.ParseBool(getEnv("SEED_DATA", "true"))
+	if err != nil {
+		log.Fatalf("Invalid SEED_DATA %q", os.Getenv("SEED_DATA"))
+	}
+	store = orderStore
+	go idempotencyKeys.cleanup(time.Hour)
+	sweepCtx, stopSweep := context.WithCancel(context.Background())
+	sweepDone := make(chan struct{})
+	if ttl := getDurationEnv("PENDING_TTL", 0); ttl > 0 {
+		interval := getDurationEnv("EXPIRY_INTERVAL", time.Minute)
+		if interval <= 0 {
+			log.Fatal("Invalid EXPIRY_INTERVAL: must be positive")
+		}
+		go sweepExpired(sweepCtx, orderStore, ttl, interval, sweepDone)
+		log.Printf("Expiring pending orders older than %s every %s", ttl, interval)
+	} else {
+		close(sweepDone)
+	}
+	startWebhooks(os.Getenv("WEBHOOK_URL"))
+	customerAPIURL = os.Getenv("CUSTOMER_API_URL")
+	customerClient.Timeout = getDurationEnv("CUSTOMER_API_TIMEOUT", customerClient.Timeout)
+	if customerAPIURL != "" {
+		log.Printf("Validating customers against %s (timeout %s)", customerAPIURL, customerClient.Timeout)
+	}
+	
+	if raw := os.Getenv("MAX_BODY_BYTES"); raw != "" {
+		n, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil || n <= 0 {
+			log.Fatalf("Invalid MAX_BODY_BYTES %q", raw)
+		}
+		maxBodyBytes = n
+	}
 	
 	http.HandleFunc("/health", healthHandler)
 	http.HandleFunc("/ready", readyHandler)
 	http.HandleFunc("/api/orders", ordersHandler)
 	http.HandleFunc("/api/orders/", orderHandler)
-	http.HandleFunc("/metrics", metricsHandler)
+	http.HandleFunc("/api/orders/bulk", bulkDeleteHandler)
+	http.HandleFunc("/api/orders/transition", transitionHandler)
+	http.HandleFunc("/api/orders/number/", orderByNumberHandler)
+	http.HandleFunc("/api/orders/search", searchOrdersHandler)
+	http.HandleFunc("/api/orders/query", queryOrdersHandler)
+	http.HandleFunc("/api/orders/summary", summaryHandler)
+	http.HandleFunc("/api/products", productsHandler)
+	http.HandleFunc("/api/products/", productHandler)
+	pathPrefix = strings.TrimSuffix(os.Getenv("PATH_PREFIX"), "/")
+	if pathPrefix != "" && !strings.HasPrefix(pathPrefix, "/") {
+		log.Fatalf("Invalid PATH_PREFIX %q: must start with /", pathPrefix)
+	}
+	metricsPath = getEnv("METRICS_PATH", metricsPath)
+	if !strings.HasPrefix(metricsPath, "/") {
+		log.Fatalf("Invalid METRICS_PATH %q: must start with /", metricsPath)
+	}
+	metricsUser, metricsPass := os.Getenv("METRICS_USER"), os.Getenv("METRICS_PASS")
+	if (metricsUser == "") != (metricsPass == "") {
+		log.Fatal("METRICS_USER and METRICS_PASS must be set together")
+	}
+	metrics := requireBasicAuth(metricsUser, metricsPass, http.HandlerFunc(metricsHandler))
+	// Both listeners share the server timeouts.
+	readTimeout := getDurationEnv("READ_TIMEOUT", 15*time.Second)
+	writeTimeout := getDurationEnv("WRITE_TIMEOUT", 15*time.Second)
+	idleTimeout := getDurationEnv("IDLE_TIMEOUT", 60*time.Second)
+	// With METRICS_PORT set, metrics and a liveness probe get their own
+	// listener, which can be kept off the interface the API is exposed on.
+	var metricsSrv *http.Server
+	metricsPort = os.Getenv("METRICS_PORT")
+	if metricsPort != "" {
+		metricsAddr := net.JoinHostPort(getEnv("METRICS_BIND_ADDR", os.Getenv("BIND_ADDR")), metricsPort)
+		if _, err := net.ResolveTCPAddr("tcp", metricsAddr); err != nil {
+			log.Fatalf("Invalid metrics listen address %q: %v", metricsAddr, err)
+		}
+		mux := http.NewServeMux()
+		mux.Handle(metricsPath, metrics)
+		mux.HandleFunc("/health", healthHandler)
+		http.HandleFunc(metricsPath, notFoundHandler)
+		metricsSrv = &http.Server{
+			Addr:         metricsAddr,
+			Handler:      recoverPanics(mux),
+			ReadTimeout:  readTimeout,
+			WriteTimeout: writeTimeout,
+			IdleTimeout:  idleTimeout,
+		}
+	} else {
+		http.Handle(metricsPath, metrics)
+	}
+	http.HandleFunc("/version", versionHandler)
+	adminEnabled, err = strconv.ParseBool(getEnv("ENABLE_ADMIN", "false"))
+	if err != nil {
+		log.Fatalf("Invalid ENABLE_ADMIN %q", os.Getenv("ENABLE_ADMIN"))
+	}
+	if adminEnabled {
+		log.Printf("Admin endpoints enabled; do not use in production")
+		http.HandleFunc("/api/admin/reset", adminResetHandler(orderStore, seedData, os.Getenv("SEED_FILE")))
+	}
+	http.HandleFunc("/api/admin/", notFoundHandler)
 	http.HandleFunc("/", rootHandler)
 	
-	port := getEnv("PORT", "8080")
-	log.Printf("✅ Order API starting on port %s", port)
-	log.Fatal(http.ListenAndServe(":"+port, nil))
+	var limiter *rateLimiter
+	if raw := os.Getenv("RATE_LIMIT_RPS"); raw != "" {
+		rps, err := strconv.ParseFloat(raw, 64)
+		if err != nil || rps < 0 {
+			log.Fatalf("Invalid RATE_LIMIT_RPS %q", raw)
+		}
+		if rps > 0 {
+			limiter = newRateLimiter(rps)
+			log.Printf("Rate limiting mutating requests to %.2f req/s per client", rps)
+		}
+	}
+	
+	if ttl := getDurationEnv("LIST_CACHE_TTL", 0); ttl > 0 {
+		orderListCache = newListCache(ttl)
+		log.Printf("Caching order list responses for up to %s", ttl)
+	}
+	recentWindow = getDurationEnv("RECENT_WINDOW", recentWindow)
+	if recentWindow == 0 {
+		log.Fatal("Invalid RECENT_WINDOW: must be positive")
+	}
+	exposeRuntimeMetrics, err = strconv.ParseBool(getEnv("EXPOSE_RUNTIME_METRICS", "true"))
+	if err != nil {
+		log.Fatalf("Invalid EXPOSE_RUNTIME_METRICS %q", os.Getenv("EXPOSE_RUNTIME_METRICS"))
+	}
+	prettyAll, err := strconv.ParseBool(getEnv("PRETTY_JSON", "false"))
+	if err != nil {
+		log.Fatalf("Invalid PRETTY_JSON %q", os.Getenv("PRETTY_JSON"))
+	}
+	corsCfg := corsConfig{
+		origin:  getEnv("CORS_ORIGIN", "*"),
+		methods: getEnv("CORS_METHODS", defaultCORSMethods),
+		headers: getEnv("CORS_HEADERS", defaultCORSHeaders),
+	}
+	if raw := os.Getenv("CORS_ALLOW_CREDENTIALS"); raw != "" {
+		credentials, err := strconv.ParseBool(raw)
+		if err != nil {
+			log.Fatalf("Invalid CORS_ALLOW_CREDENTIALS %q", raw)
+		}
+		corsCfg.credentials = credentials
+	}
+	if corsCfg.credentials && strings.Contains(corsCfg.origin, "*") {
+		log.Fatalf("CORS_ALLOW_CREDENTIALS requires CORS_ORIGIN to list explicit origins, not *")
+	}
+	
+	var handler http.Handler = http.DefaultServeMux
+	handler = waitForStore(handler)
+	handlerTimeout := getDurationEnv("HANDLER_TIMEOUT", 0)
+	bulkHandlerTimeout := getDurationEnv("BULK_HANDLER_TIMEOUT", 4*handlerTimeout)
+	handler = handlerTimeouts(handlerTimeout, bulkHandlerTimeout, handler)
+	handler = requireAPIKey(os.Getenv("API_KEY"), handler)
+	handler = rateLimit(limiter, handler)
+	handler = cors(corsCfg, handler)
+	handler = stripEnvelope(handler)
+	handler = prettyJSON(prettyAll, handler)
+	handler = gzipResponses(handler)
+	handler = recoverPanics(handler)
+	handler = accessLog(accessLogSkip(), handler)
+	handler = withRequestID(handler)
+	handler = recordDuration(handler)
+	handler = trackInFlight(handler)
+	handler = stripPathPrefix(pathPrefix, handler)
+	
+	addr := net.JoinHostPort(os.Getenv("BIND_ADDR"), getEnv("PORT", "8080"))
+	if _, err := net.ResolveTCPAddr("tcp", addr); err != nil {
+		log.Fatalf("Invalid listen address %q: %v", addr, err)
+	}
+	certFile, keyFile := os.Getenv("TLS_CERT_FILE"), os.Getenv("TLS_KEY_FILE")
+	if (certFile == "") != (keyFile == "") {
+		log.Fatalf("TLS_CERT_FILE and TLS_KEY_FILE must be set together")
+	}
+	useTLS := certFile != ""
+	
+	srv := &http.Server{
+		Addr:         addr,
+		Handler:      handler,
+		ReadTimeout:  readTimeout,
+		WriteTimeout: writeTimeout,
+		IdleTimeout:  idleTimeout,
+	}
+	conns := newConnTracker()
+	srv.ConnState = conns.track
+	shutdownTimeout := getDurationEnv("SHUTDOWN_TIMEOUT", 10*time.Second)
+	log.Printf("Server timeouts: read=%s write=%s idle=%s", srv.ReadTimeout, srv.WriteTimeout, srv.IdleTimeout)
+	
+	go func() {
+		if jsonLogs {
+			logEvent(nil, "info", "Order API starting", "addr", addr, "tls", useTLS)
+		} else {
+			log.Printf("✅ Order API starting on %s (TLS: %t)", addr, useTLS)
+		}
+		var err error
+		if useTLS {
+			err = srv.ListenAndServeTLS(certFile, keyFile)
+		} else {
+			err = srv.ListenAndServe()
+		}
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatalf("Server failed: %v", err)
+		}
+	}()
+	if metricsSrv != nil {
+		go func() {
+			log.Printf("Serving metrics on %s", metricsSrv.Addr)
+			if err := metricsSrv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				log.Fatalf("Metrics server failed: %v", err)
+			}
+		}()
+	}
+	
+	// The store is filled only once the server is listening, so /ready can
+	// report starting while a large file loads. A signal before it is ready
+	// just ends the process, as there is nothing to flush yet.
+	loaded, err := orderStore.load()
+	if err != nil {
+		log.Fatalf("Failed to load orders: %v", err)
+	}
+	if !loaded && seedData {
+		if err := initOrders(orderStore, os.Getenv("SEED_FILE")); err != nil {
+			log.Fatalf("Failed to seed orders: %v", err)
+		}
+	}
+	storeReady.Store(true)
+	
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+	sig := <-stop
+	
+	shuttingDown.Store(true)
+	log.Printf("Received %s, shutting down with %d requests in flight", sig, atomic.LoadInt64(&inFlight))
+	
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		dropped := conns.active()
+		log.Printf("Graceful shutdown did not finish within %s: %v; force-closing %d connections", shutdownTimeout, err, len(dropped))
+		for _, c := range dropped {
+			log.Printf("Dropping connection from %s (%s)", c.remote, c.state)
+		}
+		if err := srv.Close(); err != nil {
+			log.Printf("Force close failed: %v", err)
+		}
+	}
+	if metricsSrv != nil {
+		if err := metricsSrv.Shutdown(ctx); err != nil {
+			log.Printf("Metrics server shutdown did not finish: %v; force-closing", err)
+			metricsSrv.Close()
+		}
+	}
+	stopSweep()
+	<-sweepDone
+	if orderStore.file != "" {
+		if n, err := orderStore.flush(); err != nil {
+			log.Printf("Failed to flush orders to %s: %v", orderStore.file, err)
+		} else {
+			log.Printf("Flushed %d orders to %s", n, orderStore.file)
+		}
+	}
+	log.Printf("Order API stopped")
+}
+
+// connTracker follows the server's connections by state so a forced
+// shutdown can report what it cut off.
+type connTracker struct {
+	mu    sync.Mutex
+	conns map[net.Conn]http.ConnState
+}
+
+type trackedConn struct {
+	remote string
+	state  http.ConnState
+}
+
+func newConnTracker() *connTracker {
+	return &connTracker{conns: make(map[net.Conn]http.ConnState)}
 }
 
-func initOrders() {
-	orders[1] = &Order{
-		ID: 1, CustomerID: 101, ProductID: 1,
-		Quantity: 2, Total: 1999.98, Status: "completed",
-		CreatedAt: time.Now().Add(-24 * time.Hour),
+func (t *connTracker) track(c net.Conn, state http.ConnState) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	switch state {
+	case http.StateClosed, http.StateHijacked:
+		delete(t.conns, c)
+	default:
+		t.conns[c] = state
 	}
-	orders[2] = &Order{
-		ID: 2, CustomerID: 102, ProductID: 3,
-		Quantity: 1, Total: 79.99, Status: "pending",
-		CreatedAt: time.Now().Add(-2 * time.Hour),
+}
+
+// active lists connections that are not yet closed.
+func (t *connTracker) active() []trackedConn {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	list := make([]trackedConn, 0, len(t.conns))
+	for c, state := range t.conns {
+		list = append(list, trackedConn{remote: c.RemoteAddr().String(), state: state})
 	}
-	nextID = 3
-	log.Printf("Initialized %d sample orders", len(orders))
+	return list
+}
+
+// initOrders seeds the store with the orders in file, or with a couple of
+// hardcoded samples when no file is given.
+func initOrders(s *memoryStore, file string) error {
+	seed := []Order{
+		{
+			ID: 1, CustomerID: 101, ProductID: 1,
+			Quantity: 2, UnitPrice: 99999, Total: 199998, Status: statusCompleted,
+			Version: 1, CreatedAt: now().Add(-24 * time.Hour),
+		},
+		{
+			ID: 2, CustomerID: 102, ProductID: 3,
+			Quantity: 1, UnitPrice: 7999, Total: 7999, Status: statusPending,
+			Version: 1, CreatedAt: now().Add(-2 * time.Hour),
+		},
+	}
+	if file != "" {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		seed = nil
+		if err := json.Unmarshal(data, &seed); err != nil {
+			return fmt.Errorf("parse %s: %w", file, err)
+		}
+		for i := range seed {
+			o := &seed[i]
+			if o.ID <= 0 {
+				return fmt.Errorf("seed order %d in %s has no ID", i, file)
+			}
+			if o.Status == "" {
+				o.Status = initialStatus
+			} else if !isValidStatus(o.Status) {
+				return fmt.Errorf("seed order %d in %s has invalid status %q", o.ID, file, o.Status)
+			}
+			if o.Version == 0 {
+				o.Version = 1
+			}
+			if o.CreatedAt.IsZero() {
+				o.CreatedAt = now()
+			}
+		}
+	}
+	s.seed(seed)
+	log.Printf("Initialized %d sample orders", len(seed))
+	return nil
 }
 
 func healthHandler(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]interface{}{
+	err := store.Health()
+	
+	body := map[string]interface{}{
 		"status":    "healthy",
 		"service":   "order-api",
 		"timestamp": time.Now().Format(time.RFC3339),
 		"uptime":    time.Since(startTime).Seconds(),
-	})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	// A failing store is reported but stays 200: this is the liveness probe,
+	// and a restart would throw away the in-memory orders the file is behind.
+	// /ready is what takes the pod out of rotation.
+	if err != nil {
+		body["status"] = "degraded"
+		body["error"] = err.Error()
+	}
+	json.NewEncoder(w).Encode(body)
 }
 
 func readyHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{
-		"status":  "ready",
-		"service": "order-api",
-	})
+	
+	body := map[string]interface{}{"service": "order-api"}
+	status := "ready"
+	switch {
+	case !storeReady.Load():
+		status = "starting"
+	case shuttingDown.Load():
+		status = "shutting_down"
+	default:
+		checks, ok := runReadinessChecks(r.Context(), readinessChecks())
+		body["checks"] = checks
+		if !ok {
+			status = "degraded"
+		}
+	}
+	body["status"] = status
+	
+	if status != "ready" {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(body)
 }
 
 func ordersHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	switch r.Method {
 	case "GET":
-		getOrders(w)
+		getOrders(w, r)
+	case "HEAD":
+		serveHead(w, func(w http.ResponseWriter) { getOrders(w, r) })
 	case "POST":
 		createOrder(w, r)
 	default:
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
 	}
 }
 
-func getOrders(w http.ResponseWriter) {
-	ordersMutex.RLock()
-	defer ordersMutex.RUnlock()
+func getOrders(w http.ResponseWriter, r *http.Request) {
+	limit, ok := queryInt(r, "limit", defaultLimit)
+	if !ok {
+		writeError(w, http.StatusBadRequest, "Invalid limit parameter")
+		return
+	}
+	if limit > maxLimit {
+		limit = maxLimit
+	}
+	observeListLimit(limit)
+	offset, ok := queryInt(r, "offset", 0)
+	if !ok {
+		writeError(w, http.StatusBadRequest, "Invalid offset parameter")
+		return
+	}
+	filter, err := parseOrderFilter(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	sortKey := r.URL.Query().Get("sort")
+	if sortKey == "" {
+		sortKey = defaultSort
+	}
+	less, ok := orderSorts[sortKey]
+	if !ok {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("Invalid sort: %q", sortKey))
+		return
+	}
+
+	lastModified := store.LastModified()
+	// HTTP dates are whole seconds, so the store time is rounded up to the
+	// next one: any write after a Last-Modified was sent then rounds past it.
+	// Until that second has passed the header can't be in the future, so it
+	// carries the current second instead, which can never match.
+	modified := lastModified.UTC().Truncate(time.Second).Add(time.Second)
+	w.Header().Set("Last-Modified", minTime(modified, now().UTC().Truncate(time.Second)).Format(http.TimeFormat))
+	if since, err := http.ParseTime(r.Header.Get("If-Modified-Since")); err == nil && !modified.After(since) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	if orderListCache != nil {
+		if cached, ok := orderListCache.get(r.URL.RawQuery, lastModified); ok {
+			if cached.link != "" {
+				w.Header().Set("Link", cached.link)
+			}
+			w.Write(cached.body)
+			return
+		}
+	}
 	
-	list := make([]*Order, 0, len(orders))
-	for _, o := range orders {
-		list = append(list, o)
+	// Identical concurrent requests share one scan and sort. The store's
+	// modification time is part of the key so a request that starts after a
+	// write never gets a result computed before it. The shared call carries
+	// no cancellation, since one client hanging up mustn't fail the others.
+	key := fmt.Sprintf("%d|%d|%s|%s|%d", limit, offset, sortKey, r.URL.RawQuery, lastModified.UnixNano())
+	ctx := context.WithoutCancel(r.Context())
+	result, _, err := listFlights.do(key, func() (orderPage, error) {
+		found, err := store.Find(ctx, filter.matches)
+		if err != nil {
+			return orderPage{}, err
+		}
+		list := orderRefs(found)
+		sortOrders(list, less)
+		return orderPage{orders: paginate(list, offset, limit), total: len(list)}, nil
+	})
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	page, total := result.orders, result.total
+	link := paginationLinks(r, offset, limit, total)
+	if link != "" {
+		w.Header().Set("Link", link)
 	}
 	
-	log.Printf("Fetching all orders - Total: %d", len(list))
-	json.NewEncoder(w).Encode(Response{
-		Success: true,
-		Count:   len(list),
-		Data:    list,
+	logEvent(r, "debug", "Fetching all orders", "total", total, "returned", len(page))
+	if orderListCache != nil {
+		var buf bytes.Buffer
+		streamOrders(&buf, page, total)
+		orderListCache.put(r.URL.RawQuery, lastModified, buf.Bytes(), link)
+		w.Write(buf.Bytes())
+		return
+	}
+	if err := streamOrders(w, page, total); err != nil {
+		logEvent(r, "error", "Failed to stream orders", "error", err.Error())
+	}
+}
+
+// orderPage is one page of a sorted order list, shared read-only between
+// the requests in a list flight.
+type orderPage struct {
+	orders []*Order
+	total  int
+}
+
+var listFlights flightGroup[orderPage]
+
+// streamOrders writes the list Response envelope around page one order at a
+// time, so a large page is never encoded into memory as a whole. The output
+// decodes the same as the equivalent Response; only whitespace differs.
+//
+// With HANDLER_TIMEOUT set, http.TimeoutHandler buffers the whole response
+// before sending it, so the page (at most maxLimit orders) is held in full
+// after all. That is accepted so a list that outlives the timeout still gets
+// a clean 503: the list flight ignores cancellation, so a context deadline
+// in place of TimeoutHandler would not bound the request.
+func streamOrders(w io.Writer, page []*Order, total int) error {
+	if _, err := io.WriteString(w, `{"success":true,"data":[`); err != nil {
+		return err
+	}
+	enc := json.NewEncoder(w)
+	for i, o := range page {
+		if i > 0 {
+			if _, err := io.WriteString(w, ","); err != nil {
+				return err
+			}
+		}
+		if err := enc.Encode(o); err != nil {
+			return err
+		}
+	}
+	_, err := fmt.Fprintf(w, "],\"count\":%d,\"returned\":%d}\n", total, len(page))
+	return err
+}
+
+func paginationLinks(r *http.Request, offset, limit, total int) string {
+	if limit == 0 {
+		return ""
+	}
+	link := func(rel string, off int) string {
+		q := r.URL.Query()
+		q.Set("offset", strconv.Itoa(off))
+		q.Set("limit", strconv.Itoa(limit))
+		u := url.URL{Path: pathPrefix + r.URL.Path, RawQuery: q.Encode()}
+		return fmt.Sprintf("<%s>; rel=%q", u.String(), rel)
+	}
+	
+	last := 0
+	if total > 0 {
+		last = (total - 1) / limit * limit
+	}
+	links := []string{link("first", 0)}
+	if offset > 0 {
+		prev := offset - limit
+		if prev < 0 {
+			prev = 0
+		}
+		links = append(links, link("prev", prev))
+	}
+	// offset is the client's and can be near MaxInt, so offset+limit is
+	// only formed once it is known to be below total.
+	if offset < total-limit {
+		links = append(links, link("next", offset+limit))
+	}
+	links = append(links, link("last", last))
+	return strings.Join(links, ", ")
+}
+
+type orderFilter struct {
+	statuses           map[string]bool
+	customerID         int
+	productID          int
+	minTotal, maxTotal *Money
+	tag                string
+	from, to           time.Time
+	includeDeleted     bool
+}
+
+func parseOrderFilter(r *http.Request) (orderFilter, error) {
+	f := orderFilter{includeDeleted: queryBool(r, "include_deleted")}
+	if raw := r.URL.Query().Get("status"); raw != "" {
+		f.statuses = make(map[string]bool)
+		for _, s := range strings.Split(raw, ",") {
+			s = normalizeStatus(s)
+			if !isValidStatus(s) {
+				return f, fmt.Errorf("Invalid status: %q", s)
+			}
+			f.statuses[s] = true
+		}
+	}
+	f.tag = normalizeTag(r.URL.Query().Get("tag"))
+	if raw := r.URL.Query().Get("customer_id"); raw != "" {
+		id, err := strconv.Atoi(raw)
+		if err != nil {
+			return f, fmt.Errorf("Invalid customer_id: %q is not an integer", raw)
+		}
+		f.customerID = id
+	}
+	for _, p := range []struct {
+		key string
+		dst **Money
+	}{{"min_total", &f.minTotal}, {"max_total", &f.maxTotal}} {
+		if raw := r.URL.Query().Get(p.key); raw != "" {
+			v, err := parseMoney(raw)
+			if err != nil {
+				return f, fmt.Errorf("Invalid %s: %q is not a number", p.key, raw)
+			}
+			*p.dst = &v
+		}
+	}
+	if f.minTotal != nil && f.maxTotal != nil && *f.minTotal > *f.maxTotal {
+		return f, errors.New("Invalid range: min_total is greater than max_total")
+	}
+	for _, p := range []struct {
+		key string
+		dst *time.Time
+	}{{"from", &f.from}, {"to", &f.to}} {
+		if raw := r.URL.Query().Get(p.key); raw != "" {
+			t, err := time.Parse(time.RFC3339, raw)
+			if err != nil {
+				return f, fmt.Errorf("Invalid %s: %q is not an RFC3339 timestamp", p.key, raw)
+			}
+			*p.dst = t
+		}
+	}
+	if !f.from.IsZero() && !f.to.IsZero() && f.from.After(f.to) {
+		return f, errors.New("Invalid range: from is after to")
+	}
+	return f, nil
+}
+
+func (f orderFilter) matches(o *Order) bool {
+	if o.DeletedAt != nil && !f.includeDeleted {
+		return false
+	}
+	if f.statuses != nil && !f.statuses[o.Status] {
+		return false
+	}
+	if f.customerID != 0 && o.CustomerID != f.customerID {
+		return false
+	}
+	if f.productID != 0 && !hasProduct(o, f.productID) {
+		return false
+	}
+	if f.minTotal != nil && o.Total < *f.minTotal {
+		return false
+	}
+	if f.tag != "" && !slices.Contains(o.Tags, f.tag) {
+		return false
+	}
+	if f.maxTotal != nil && o.Total > *f.maxTotal {
+		return false
+	}
+	if !f.from.IsZero() && o.CreatedAt.Before(f.from) {
+		return false
+	}
+	if !f.to.IsZero() && o.CreatedAt.After(f.to) {
+		return false
+	}
+	return true
+}
+
+func hasProduct(o *Order, productID int) bool {
+	if o.ProductID == productID {
+		return true
+	}
+	for _, item := range o.Items {
+		if item.ProductID == productID {
+			return true
+		}
+	}
+	return false
+}
+
+// orderRefs returns pointers into list, the form sortOrders and paginate
+// work on.
+func orderRefs(list []Order) []*Order {
+	refs := make([]*Order, len(list))
+	for i := range list {
+		refs[i] = &list[i]
+	}
+	return refs
+}
+
+func sortOrders(list []*Order, less func(a, b *Order) bool) {
+	sort.Slice(list, func(i, j int) bool {
+		if less(list[i], list[j]) {
+			return true
+		}
+		if less(list[j], list[i]) {
+			return false
+		}
+		return list[i].ID < list[j].ID
 	})
 }
 
+func paginate(list []*Order, offset, limit int) []*Order {
+	if offset >= len(list) {
+		return []*Order{}
+	}
+	end := offset + limit
+	if end > len(list) {
+		end = len(list)
+	}
+	return list[offset:end]
+}
+
 func createOrder(w http.ResponseWriter, r *http.Request) {
 	var order Order
-	if err := json.NewDecoder(r.Body).Decode(&order); err != nil {
-		http.Error(w, "Invalid request", http.StatusBadRequest)
+	if !decodeValidBody(w, r, &order, orderSchema, "application/json") {
 		return
 	}
 	
-	if order.CustomerID == 0 || order.ProductID == 0 || order.Quantity == 0 {
-		http.Error(w, "Missing required fields", http.StatusBadRequest)
+	if err := validateOrder(&order); err != nil {
+		writeError(w, http.StatusUnprocessableEntity, err.Error())
 		return
 	}
+	if err := priceOrder(&order); err != nil {
+		writeError(w, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+	// Imports from a legacy system may keep their original creation time;
+	// everything else is stamped with the server's.
+	createdAt := now()
+	if queryBool(r, "preserve_created_at") {
+		if !adminEnabled {
+			writeError(w, http.StatusForbidden, "preserve_created_at requires ENABLE_ADMIN")
+			return
+		}
+		if order.CreatedAt.After(createdAt) {
+			writeError(w, http.StatusUnprocessableEntity, "Invalid created_at: must not be in the future")
+			return
+		}
+		if !order.CreatedAt.IsZero() {
+			createdAt = order.CreatedAt
+		}
+	}
+	order.CreatedAt = createdAt
+	order.Status = initialStatus
+	order.Version = 1
+	order.DeletedAt = nil
+	clearStatusTimes(&order)
+	recordChange(r, &order, "", "")
+	order.History[len(order.History)-1].Timestamp = createdAt
 	
-	ordersMutex.Lock()
-	order.ID = nextID
-	nextID++
-	order.CreatedAt = time.Now()
-	order.Status = "pending"
-	orders[order.ID] = &order
-	ordersMutex.Unlock()
+	// The customer is checked before claiming the idempotency key, so
+	// retries waiting on the key only wait on the store.
+	if err := checkCustomer(r.Context(), order.CustomerID); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	create := func() (Order, error) {
+		return store.Create(r.Context(), order)
+	}
+	var (
+		created  Order
+		replayed bool
+		err      error
+	)
+	if key := r.Header.Get("Idempotency-Key"); key != "" {
+		created, replayed, err = idempotencyKeys.once(key, create)
+	} else {
+		created, err = create()
+	}
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	if replayed {
+		logEvent(r, "debug", "Replaying idempotent create", "order_id", created.ID)
+		w.Header().Set("Idempotent-Replayed", "true")
+		w.Header().Set("Location", orderLocation(created))
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(Response{Success: true, Data: created})
+		return
+	}
 	
-	log.Printf("Order created: %d", order.ID)
+	logEvent(r, "debug", "Order created", "order_id", created.ID)
+	notifyWebhook(eventOrderCreated, created)
+	atomic.AddUint64(&ordersCreatedTotal, 1)
 	
+	w.Header().Set("Location", orderLocation(created))
 	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode(Response{Success: true, Data: order})
+	json.NewEncoder(w).Encode(Response{Success: true, Data: created})
+}
+
+// orderLocation is the URL a created order can be fetched from, by its
+// order number when it has one.
+func orderLocation(o Order) string {
+	if o.OrderNumber != "" {
+		return pathPrefix + "/api/orders/number/" + url.PathEscape(o.OrderNumber)
+	}
+	return pathPrefix + "/api/orders/" + strconv.Itoa(o.ID)
+}
+
+// checkVersion rejects writes based on a stale read. The expected version
+// comes from If-Match (a version number or the order's ETag) or, failing
+// that, from the request body; zero means the client didn't ask.
+func checkVersion(r *http.Request, o *Order, bodyVersion int) error {
+	if match := r.Header.Get("If-Match"); match != "" {
+		if etagMatches(match, orderETag(o)) {
+			return nil
+		}
+		v, err := strconv.Atoi(strings.Trim(strings.TrimPrefix(match, "W/"), `"`))
+		if err != nil || v != o.Version {
+			return fmt.Errorf("Version mismatch: order %d is at version %d", o.ID, o.Version)
+		}
+		return nil
+	}
+	if bodyVersion != 0 && bodyVersion != o.Version {
+		return fmt.Errorf("Version mismatch: order %d is at version %d", o.ID, o.Version)
+	}
+	return nil
+}
+
+// errOrderDeleted is reported instead of errOrderNotFound for soft-deleted
+// orders, so clients can tell a repeated delete from a bad ID.
+var errOrderDeleted = errors.New("Order has been deleted")
+
+// statusError is returned from store update callbacks to reject a mutation
+// with a specific HTTP status.
+type statusError struct {
+	status int
+	msg    string
+}
+
+func (e *statusError) Error() string { return e.msg }
+
+// writeStoreError reports an error from the store or one of its update
+// callbacks.
+func writeStoreError(w http.ResponseWriter, err error) {
+	var se *statusError
+	switch {
+	case errors.As(err, &se):
+		writeError(w, se.status, se.msg)
+	case errors.Is(err, errOrderNotFound):
+		writeErrorCode(w, http.StatusNotFound, "order_not_found", err.Error())
+	case errors.Is(err, errOrderDeleted):
+		writeErrorCode(w, http.StatusGone, "order_deleted", err.Error())
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		writeError(w, http.StatusServiceUnavailable, "Request cancelled: "+err.Error())
+	case errors.Is(err, errCustomerLimit):
+		writeErrorCode(w, http.StatusConflict, "customer_limit_reached", err.Error())
+	case errors.Is(err, errStoreFull):
+		writeError(w, http.StatusInsufficientStorage, err.Error())
+	default:
+		writeError(w, http.StatusInternalServerError, err.Error())
+	}
+}
+
+func validateOrder(o *Order) error {
+	if len(o.Items) > 0 {
+		if o.CustomerID == 0 {
+			return errors.New("Missing required fields")
+		}
+		if o.CustomerID < 0 {
+			return fmt.Errorf("Invalid customer_id %d: must be positive", o.CustomerID)
+		}
+		quantity := 0
+		for i, item := range o.Items {
+			if item.ProductID == 0 || item.Quantity == 0 {
+				return fmt.Errorf("Missing required fields in item %d", i)
+			}
+			if item.ProductID < 0 {
+				return fmt.Errorf("Invalid product_id %d in item %d: must be positive", item.ProductID, i)
+			}
+			if item.Quantity < 1 || item.Quantity > maxQuantity {
+				return fmt.Errorf("Invalid quantity %d in item %d: must be between 1 and %d", item.Quantity, i, maxQuantity)
+			}
+			quantity += item.Quantity
+		}
+		if quantity > maxQuantity {
+			return fmt.Errorf("Invalid quantity %d across items: must be at most %d", quantity, maxQuantity)
+		}
+	} else {
+		if o.CustomerID == 0 || o.ProductID == 0 || o.Quantity == 0 {
+			return errors.New("Missing required fields")
+		}
+		if o.CustomerID < 0 {
+			return fmt.Errorf("Invalid customer_id %d: must be positive", o.CustomerID)
+		}
+		if o.ProductID < 0 {
+			return fmt.Errorf("Invalid product_id %d: must be positive", o.ProductID)
+		}
+		if o.Quantity < 1 || o.Quantity > maxQuantity {
+			return fmt.Errorf("Invalid quantity %d: must be between 1 and %d", o.Quantity, maxQuantity)
+		}
+	}
+	if o.Total < 0 {
+		return fmt.Errorf("Invalid total %s: must not be negative", o.Total)
+	}
+	tags, err := normalizeTags(o.Tags)
+	if err != nil {
+		return err
+	}
+	o.Tags = tags
+	return validateNotes(o.Notes)
+}
+
+const maxNotesLength = 1000
+
+// maxQuantity caps the units in one order, across all its items, to catch
+// typos; it is set from MAX_QUANTITY.
+var maxQuantity = 10000
+
+func validateNotes(notes string) error {
+	if n := utf8.RuneCountInString(notes); n > maxNotesLength {
+		return fmt.Errorf("Notes too long: %d characters, max %d", n, maxNotesLength)
+	}
+	return nil
 }
 
 func orderHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
-	idStr := r.URL.Path[len("/api/orders/"):]
+	idStr, action, _ := strings.Cut(r.URL.Path[len("/api/orders/"):], "/")
 	id, err := strconv.Atoi(idStr)
-	if err != nil {
-		http.Error(w, "Invalid order ID", http.StatusBadRequest)
+	if err != nil || id < 1 {
+		writeError(w, http.StatusBadRequest, "Invalid order ID")
+		return
+	}
+	
+	if action != "" {
+		orderActionHandler(w, r, id, action)
 		return
 	}
 	
 	switch r.Method {
 	case "GET":
-		getOrder(w, id)
+		getOrder(w, r, id)
+	case "HEAD":
+		serveHead(w, func(w http.ResponseWriter) { getOrder(w, r, id) })
 	case "PUT":
+		replaceOrder(w, r, id)
+	case "PATCH":
 		updateOrder(w, r, id)
 	case "DELETE":
-		deleteOrder(w, id)
+		deleteOrder(w, r, id)
 	default:
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
 	}
 }
 
-func getOrder(w http.ResponseWriter, id int) {
-	ordersMutex.RLock()
-	order, exists := orders[id]
-	ordersMutex.RUnlock()
+func orderActionHandler(w http.ResponseWriter, r *http.Request, id int, action string) {
+	switch action {
+	case "restore":
+		if r.Method != "POST" {
+			writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+			return
+		}
+		restoreOrder(w, r, id)
+	case "cancel":
+		if r.Method != "POST" {
+			writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+			return
+		}
+		cancelOrder(w, r, id)
+	case "history":
+		if r.Method != "GET" {
+			writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+			return
+		}
+		orderHistory(w, r, id)
+	case "tags":
+		tagsHandler(w, r, id)
+	default:
+		writeError(w, http.StatusNotFound, "Not found")
+	}
+}
+
+func getOrder(w http.ResponseWriter, r *http.Request, id int) {
+	order, err := store.Get(r.Context(), id)
+	serveOrder(w, r, order, err)
+}
+
+func orderByNumberHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != "GET" {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	order, err := store.GetByNumber(r.Context(), r.URL.Path[len("/api/orders/number/"):])
+	serveOrder(w, r, order, err)
+}
+
+// serveOrder writes the result of a single-order lookup, hiding soft-deleted
+// orders unless include_deleted is set.
+func serveOrder(w http.ResponseWriter, r *http.Request, order Order, err error) {
+	if err == nil && order.DeletedAt != nil && !queryBool(r, "include_deleted") {
+		err = errOrderDeleted
+	}
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
 	
-	if !exists {
-		http.Error(w, "Order not found", http.StatusNotFound)
+	etag := orderETag(&order)
+	w.Header().Set("ETag", etag)
+	if etagMatches(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
 		return
 	}
 	json.NewEncoder(w).Encode(Response{Success: true, Data: order})
 }
 
+func orderETag(o *Order) string {
+	h := fnv.New64a()
+	json.NewEncoder(h).Encode(o)
+	return fmt.Sprintf(`W/"%x"`, h.Sum64())
+}
+
+func etagMatches(header, etag string) bool {
+	if header == "" {
+		return false
+	}
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+// replaceOrder handles PUT. With ?upsert=true a missing order is created
+// under the requested ID instead of reporting 404.
+func replaceOrder(w http.ResponseWriter, r *http.Request, id int) {
+	var replacement Order
+	if !decodeValidBody(w, r, &replacement, orderSchema, "application/json") {
+		return
+	}
+	replacement.Status = normalizeStatus(replacement.Status)
+	
+	upsert := queryBool(r, "upsert")
+	// Only a customer new to the order is checked, outside the store lock.
+	current, err := store.Get(r.Context(), id)
+	if (err == nil && current.CustomerID != replacement.CustomerID) || (errors.Is(err, errOrderNotFound) && upsert) {
+		if err := checkCustomer(r.Context(), replacement.CustomerID); err != nil {
+			writeStoreError(w, err)
+			return
+		}
+	}
+	var from string
+	order, created, err := store.Upsert(r.Context(), id, func(order *Order, exists bool) error {
+		if !exists && !upsert {
+			return errOrderNotFound
+		}
+		if exists && order.DeletedAt != nil {
+			return errOrderDeleted
+		}
+		if exists {
+			if err := checkVersion(r, order, replacement.Version); err != nil {
+				return &statusError{http.StatusConflict, err.Error()}
+			}
+		}
+		if err := validateOrder(&replacement); err != nil {
+			return &statusError{http.StatusUnprocessableEntity, err.Error()}
+		}
+		if err := priceOrder(&replacement); err != nil {
+			return &statusError{http.StatusUnprocessableEntity, err.Error()}
+		}
+		// A created order starts at initialStatus, as through POST; the
+		// status machine is how it gets anywhere else.
+		if !exists {
+			replacement.Status = initialStatus
+		}
+		if !isValidStatus(replacement.Status) {
+			return &statusError{http.StatusUnprocessableEntity, "Invalid status"}
+		}
+		
+		if !exists {
+			replacement.CreatedAt = now()
+			replacement.Version = 1
+			replacement.DeletedAt = nil
+			clearStatusTimes(&replacement)
+			recordChange(r, &replacement, "", "")
+			*order = replacement
+			return nil
+		}
+		if err := checkTransition(order.Status, replacement.Status); err != nil {
+			return &statusError{http.StatusUnprocessableEntity, err.Error()}
+		}
+		replacement.ID = order.ID
+		replacement.OrderNumber = order.OrderNumber
+		replacement.CreatedAt = order.CreatedAt
+		replacement.Version = order.Version + 1
+		replacement.History = order.History
+		replacement.DeletedAt = order.DeletedAt
+		replacement.CancelledAt = order.CancelledAt
+		replacement.ProcessingAt = order.ProcessingAt
+		replacement.ShippedAt = order.ShippedAt
+		replacement.CompletedAt = order.CompletedAt
+		from = order.Status
+		recordChange(r, &replacement, from, order.Notes)
+		*order = replacement
+		return nil
+	})
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	
+	if created {
+		notifyWebhook(eventOrderCreated, order)
+		atomic.AddUint64(&ordersCreatedTotal, 1)
+		logEvent(r, "debug", "Order created by upsert", "order_id", id)
+		w.Header().Set("Location", orderLocation(order))
+		w.WriteHeader(http.StatusCreated)
+	} else {
+		notifyWebhook(updateEvent(from, order), order)
+		atomic.AddUint64(&ordersUpdatedTotal, 1)
+		logEvent(r, "debug", "Order replaced", "order_id", id)
+	}
+	json.NewEncoder(w).Encode(Response{Success: true, Data: order})
+}
+
+// orderPatch is a JSON Merge Patch (RFC 7386) of an order. Only these fields
+// may be patched:
+//
+//   - status: moves the order through the state machine; cannot be null.
+//   - notes: replaces the notes; null or "" clears them.
+//   - quantity: reprices a single-product order; cannot be null.
+//   - version: the version the patch was based on; null or 0 skips the check.
+//
+// Fields left out of the body are left alone, and any other field is rejected.
+type orderPatch struct {
+	Status   patchValue[string] `json:"status"`
+	Notes    patchValue[string] `json:"notes"`
+	Quantity patchValue[int]    `json:"quantity"`
+	Version  patchValue[int]    `json:"version"`
+}
+
+// patchValue records whether a merge patch member was present and whether it
+// was null, which the zero value of T alone can't tell apart.
+type patchValue[T any] struct {
+	set   bool
+	null  bool
+	value T
+}
+
+func (p *patchValue[T]) UnmarshalJSON(data []byte) error {
+	p.set = true
+	if bytes.Equal(data, []byte("null")) {
+		p.null = true
+		return nil
+	}
+	return json.Unmarshal(data, &p.value)
+}
+
 func updateOrder(w http.ResponseWriter, r *http.Request, id int) {
-	ordersMutex.Lock()
-	defer ordersMutex.Unlock()
+	var patch orderPatch
+	if !decodeValidBody(w, r, &patch, orderPatchSchema, "application/merge-patch+json", "application/json") {
+		return
+	}
+	patch.Status.value = normalizeStatus(patch.Status.value)
+	if patch.Status.null || patch.Quantity.null {
+		writeError(w, http.StatusUnprocessableEntity, "Only notes can be cleared with null")
+		return
+	}
 	
-	order, exists := orders[id]
-	if !exists {
-		http.Error(w, "Order not found", http.StatusNotFound)
+	var from string
+	order, err := store.Update(r.Context(), id, func(order *Order) error {
+		if order.DeletedAt != nil {
+			return errOrderDeleted
+		}
+		if err := checkVersion(r, order, patch.Version.value); err != nil {
+			return &statusError{http.StatusConflict, err.Error()}
+		}
+		
+		from = order.Status
+		fromNotes := order.Notes
+		if patch.Status.set {
+			if !isValidStatus(patch.Status.value) {
+				return &statusError{http.StatusUnprocessableEntity, "Invalid status"}
+			}
+			if err := checkTransition(order.Status, patch.Status.value); err != nil {
+				return &statusError{http.StatusUnprocessableEntity, err.Error()}
+			}
+			order.Status = patch.Status.value
+		}
+		if patch.Notes.set {
+			if err := validateNotes(patch.Notes.value); err != nil {
+				return &statusError{http.StatusUnprocessableEntity, err.Error()}
+			}
+			order.Notes = patch.Notes.value
+		}
+		if patch.Quantity.set {
+			if len(order.Items) > 0 {
+				return &statusError{http.StatusUnprocessableEntity, "Quantity of a multi-item order is set per item"}
+			}
+			order.Quantity = patch.Quantity.value
+			if err := validateOrder(order); err != nil {
+				return &statusError{http.StatusUnprocessableEntity, err.Error()}
+			}
+			if err := priceOrder(order); err != nil {
+				return &statusError{http.StatusUnprocessableEntity, err.Error()}
+			}
+		}
+		recordChange(r, order, from, fromNotes)
+		order.Version++
+		return nil
+	})
+	if err != nil {
+		writeStoreError(w, err)
 		return
 	}
+	notifyWebhook(updateEvent(from, order), order)
+	atomic.AddUint64(&ordersUpdatedTotal, 1)
 	
-	var updates Order
-	if err := json.NewDecoder(r.Body).Decode(&updates); err != nil {
-		http.Error(w, "Invalid request", http.StatusBadRequest)
+	logEvent(r, "debug", "Order updated", "order_id", id)
+	json.NewEncoder(w).Encode(Response{Success: true, Data: order})
+}
+
+func deleteOrder(w http.ResponseWriter, r *http.Request, id int) {
+	hard := queryBool(r, "hard")
+	removed, err := removeOrder(r.Context(), id, hard)
+	if err != nil {
+		writeStoreError(w, err)
 		return
 	}
+	notifyWebhook(eventOrderDeleted, removed)
+	atomic.AddUint64(&ordersDeletedTotal, 1)
+	logEvent(r, "debug", "Order deleted", "order_id", id, "hard", hard)
 	
-	if updates.Status != "" {
-		order.Status = updates.Status
+	json.NewEncoder(w).Encode(Response{
+		Success: true,
+		Data:    map[string]string{"message": fmt.Sprintf("Order %d deleted", id)},
+	})
+}
+
+// recordChange appends an event to the order's history if its status or
+// notes differ from the given previous values, and stamps the time a new
+// status was entered.
+func recordChange(r *http.Request, o *Order, from, fromNotes string) {
+	if from == o.Status && fromNotes == o.Notes {
+		return
+	}
+	at := now()
+	if from != o.Status {
+		stampStatusTime(o, at)
+	}
+	event := OrderEvent{
+		Timestamp: at,
+		From:      from,
+		To:        o.Status,
+		Actor:     actor(r),
+	}
+	if o.Notes != fromNotes {
+		notes := o.Notes
+		event.Notes = &notes
 	}
-	if updates.Quantity > 0 {
-		order.Quantity = updates.Quantity
+	o.History = append(o.History, event)
+}
+
+// removeOrder soft-deletes the order, or drops it from the store entirely
+// when hard is set, and returns the order as it was left.
+func removeOrder(ctx context.Context, id int, hard bool) (Order, error) {
+	if hard {
+		return store.Delete(ctx, id)
+	}
+	return store.Update(ctx, id, markDeleted)
+}
+
+// markDeleted is the store update behind a soft delete.
+func markDeleted(o *Order) error {
+	if o.DeletedAt != nil {
+		return errOrderDeleted
+	}
+	deletedAt := now()
+	o.DeletedAt = &deletedAt
+	o.Version++
+	return nil
+}
+
+func cancelOrder(w http.ResponseWriter, r *http.Request, id int) {
+	order, err := store.Update(r.Context(), id, func(order *Order) error {
+		if order.DeletedAt != nil {
+			return errOrderDeleted
+		}
+		if order.Status != statusPending && order.Status != statusProcessing {
+			return &statusError{http.StatusUnprocessableEntity, fmt.Sprintf("Cannot cancel %s order", order.Status)}
+		}
+		from := order.Status
+		order.Status = statusCancelled
+		recordChange(r, order, from, order.Notes)
+		order.Version++
+		return nil
+	})
+	if err != nil {
+		writeStoreError(w, err)
+		return
 	}
+	notifyWebhook(eventOrderCancelled, order)
+	atomic.AddUint64(&ordersUpdatedTotal, 1)
 	
-	log.Printf("Order updated: %d", id)
+	logEvent(r, "debug", "Order cancelled", "order_id", id)
 	json.NewEncoder(w).Encode(Response{Success: true, Data: order})
 }
 
-func deleteOrder(w http.ResponseWriter, id int) {
-	ordersMutex.Lock()
-	defer ordersMutex.Unlock()
+// orderHistory serves the audit trail, including for soft-deleted orders.
+func orderHistory(w http.ResponseWriter, r *http.Request, id int) {
+	order, err := store.Get(r.Context(), id)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	json.NewEncoder(w).Encode(Response{
+		Success: true,
+		Count:   counted(len(order.History)),
+		Data:    emptyIfNil(order.History),
+	})
+}
+
+func restoreOrder(w http.ResponseWriter, r *http.Request, id int) {
+	order, err := store.Update(r.Context(), id, func(order *Order) error {
+		if order.DeletedAt == nil {
+			return &statusError{http.StatusConflict, "Order is not deleted"}
+		}
+		order.DeletedAt = nil
+		order.Version++
+		return nil
+	})
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	notifyWebhook(eventOrderUpdated, order)
+	atomic.AddUint64(&ordersUpdatedTotal, 1)
+	
+	logEvent(r, "debug", "Order restored", "order_id", id)
+	json.NewEncoder(w).Encode(Response{Success: true, Data: order})
+}
+
+func searchOrdersHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != "GET" {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	q := strings.TrimSpace(r.URL.Query().Get("q"))
+	if q == "" {
+		writeError(w, http.StatusBadRequest, "Missing search query parameter q")
+		return
+	}
+	
+	found, err := store.Find(r.Context(), func(o *Order) bool {
+		return o.DeletedAt == nil && matchesSearch(o, q)
+	})
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	results := orderRefs(found)
+	sortOrders(results, orderSorts[defaultSort])
 	
-	if _, exists := orders[id]; !exists {
-		http.Error(w, "Order not found", http.StatusNotFound)
+	logEvent(r, "debug", "Searched orders", "query", q, "matches", len(results))
+	json.NewEncoder(w).Encode(Response{Success: true, Count: counted(len(results)), Data: emptyIfNil(results)})
+}
+
+// orderQuery is the body of POST /api/orders/query. All fields are optional
+// and the ones given must all match:
+//
+//   - status: the order has any of the listed statuses
+//   - customer_id: the order belongs to this customer
+//   - product_id: the order is for this product, directly or in a line item
+//   - min_total, max_total: inclusive bounds on the order total
+//   - from, to: inclusive bounds on created_at, as RFC3339 timestamps
+//   - include_deleted: also match soft-deleted orders
+type orderQuery struct {
+	Status         []string   `json:"status"`
+	CustomerID     int        `json:"customer_id"`
+	ProductID      int        `json:"product_id"`
+	MinTotal       *Money     `json:"min_total"`
+	MaxTotal       *Money     `json:"max_total"`
+	From           *time.Time `json:"from"`
+	To             *time.Time `json:"to"`
+	IncludeDeleted bool       `json:"include_deleted"`
+}
+
+func (q orderQuery) filter() (orderFilter, error) {
+	f := orderFilter{
+		customerID:     q.CustomerID,
+		productID:      q.ProductID,
+		minTotal:       q.MinTotal,
+		maxTotal:       q.MaxTotal,
+		includeDeleted: q.IncludeDeleted,
+	}
+	if len(q.Status) > 0 {
+		f.statuses = make(map[string]bool, len(q.Status))
+		for _, s := range q.Status {
+			s = normalizeStatus(s)
+			if !isValidStatus(s) {
+				return f, fmt.Errorf("Invalid status: %q", s)
+			}
+			f.statuses[s] = true
+		}
+	}
+	if q.From != nil {
+		f.from = *q.From
+	}
+	if q.To != nil {
+		f.to = *q.To
+	}
+	if !f.from.IsZero() && !f.to.IsZero() && f.from.After(f.to) {
+		return f, errors.New("Invalid range: from is after to")
+	}
+	if f.minTotal != nil && f.maxTotal != nil && *f.minTotal > *f.maxTotal {
+		return f, errors.New("Invalid range: min_total is greater than max_total")
+	}
+	return f, nil
+}
+
+func queryOrdersHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != "POST" {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
 		return
 	}
 	
-	delete(orders, id)
-	log.Printf("Order deleted: %d", id)
+	var q orderQuery
+	if !decodeBody(w, r, &q) {
+		return
+	}
+	filter, err := q.filter()
+	if err != nil {
+		writeError(w, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+	
+	found, err := store.Find(r.Context(), filter.matches)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	results := orderRefs(found)
+	sortOrders(results, orderSorts[defaultSort])
+	
+	logEvent(r, "debug", "Queried orders", "matches", len(results))
+	json.NewEncoder(w).Encode(Response{Success: true, Count: counted(len(results)), Data: emptyIfNil(results)})
+}
+
+// matchesSearch reports whether q is o's status or a prefix of one of its
+// IDs or its total. Product IDs are matched on the line items too, as for
+// ?product_id.
+func matchesSearch(o *Order, q string) bool {
+	if strings.EqualFold(o.Status, q) {
+		return true
+	}
+	fields := []string{
+		strconv.Itoa(o.ID),
+		strconv.Itoa(o.CustomerID),
+		strconv.Itoa(o.ProductID),
+		o.Total.String(),
+	}
+	for _, item := range o.Items {
+		fields = append(fields, strconv.Itoa(item.ProductID))
+	}
+	for _, field := range fields {
+		if strings.HasPrefix(field, q) {
+			return true
+		}
+	}
+	return false
+}
+
+type orderSummary struct {
+	InitialStatus string         `json:"initial_status"`
+	Count         int            `json:"count"`
+	ByStatus      map[string]int `json:"by_status"`
+	TotalValue    Money          `json:"total_value"`
+	AverageValue  Money          `json:"average_value"`
+	MinTotal      Money          `json:"min_total"`
+	MaxTotal      Money          `json:"max_total"`
+
+	// TimeToShip and TimeToComplete are the mean seconds from creation to
+	// shipped_at and completed_at, over the orders that have reached them;
+	// null when none have.
+	TimeToShip     *float64 `json:"time_to_ship"`
+	TimeToComplete *float64 `json:"time_to_complete"`
+}
+
+// meanSince averages the seconds from each order's creation to the time
+// picked by at, skipping orders where at is nil.
+func meanSince(orders []Order, at func(o *Order) *time.Time) *float64 {
+	var sum float64
+	n := 0
+	for i := range orders {
+		o := &orders[i]
+		if t := at(o); t != nil && o.DeletedAt == nil {
+			sum += t.Sub(o.CreatedAt).Seconds()
+			n++
+		}
+	}
+	if n == 0 {
+		return nil
+	}
+	mean := roundCents(sum / float64(n))
+	return &mean
+}
+
+func summaryHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != "GET" {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	
+	summary := orderSummary{
+		InitialStatus: initialStatus,
+		ByStatus:      make(map[string]int, len(validStatuses)),
+	}
+	for _, status := range validStatuses {
+		summary.ByStatus[status] = 0
+	}
 	
+	all, err := store.List(r.Context())
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	for _, o := range all {
+		if o.DeletedAt != nil {
+			continue
+		}
+		if summary.Count == 0 || o.Total < summary.MinTotal {
+			summary.MinTotal = o.Total
+		}
+		if summary.Count == 0 || o.Total > summary.MaxTotal {
+			summary.MaxTotal = o.Total
+		}
+		summary.Count++
+		summary.ByStatus[o.Status]++
+		summary.TotalValue += o.Total
+	}
+	
+	if summary.Count > 0 {
+		summary.AverageValue = Money(math.Round(float64(summary.TotalValue) / float64(summary.Count)))
+	}
+	summary.TimeToShip = meanSince(all, func(o *Order) *time.Time { return o.ShippedAt })
+	summary.TimeToComplete = meanSince(all, func(o *Order) *time.Time { return o.CompletedAt })
+	
+	json.NewEncoder(w).Encode(Response{Success: true, Data: summary})
+}
+
+func bulkDeleteHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != "DELETE" {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	
+	var req struct {
+		IDs []int `json:"ids"`
+	}
+	if !decodeBody(w, r, &req) {
+		return
+	}
+	if len(req.IDs) == 0 {
+		writeError(w, http.StatusUnprocessableEntity, "Invalid request")
+		return
+	}
+	
+	hard := queryBool(r, "hard")
+	deleted := make([]int, 0, len(req.IDs))
+	notFound := make([]int, 0)
+	alreadyDeleted := make([]int, 0)
+	
+	var (
+		removed []Order
+		errs    []error
+		err     error
+	)
+	if hard {
+		removed, errs, err = store.DeleteMany(r.Context(), req.IDs)
+	} else {
+		removed, errs, err = store.UpdateMany(r.Context(), req.IDs, markDeleted)
+	}
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	for i, id := range req.IDs {
+		if errors.Is(errs[i], errOrderDeleted) {
+			alreadyDeleted = append(alreadyDeleted, id)
+			continue
+		}
+		if errs[i] != nil {
+			notFound = append(notFound, id)
+			continue
+		}
+		deleted = append(deleted, id)
+		notifyWebhook(eventOrderDeleted, removed[i])
+		atomic.AddUint64(&ordersDeletedTotal, 1)
+	}
+	
+	logEvent(r, "debug", "Orders bulk deleted", "deleted", len(deleted), "not_found", len(notFound), "already_deleted", len(alreadyDeleted))
 	json.NewEncoder(w).Encode(Response{
 		Success: true,
-		Data:    map[string]string{"message": fmt.Sprintf("Order %d deleted", id)},
+		Count:   counted(len(deleted)),
+		Data: map[string][]int{
+			"deleted":         emptyIfNil(deleted),
+			"not_found":       emptyIfNil(notFound),
+			"already_deleted": emptyIfNil(alreadyDeleted),
+		},
 	})
 }
 
-func metricsHandler(w http.ResponseWriter, r *http.Request) {
-	ordersMutex.RLock()
-	count := len(orders)
-	ordersMutex.RUnlock()
+// transitionResult is the outcome for one ID of a batch transition.
+type transitionResult struct {
+	ID      int    `json:"id"`
+	Success bool   `json:"success"`
+	Status  string `json:"status,omitempty"`
+	Error   string `json:"error,omitempty"`
+	Code    string `json:"code,omitempty"`
+}
+
+// transitionHandler moves every listed order to one status in a single store
+// update, checking each against the state machine on its own so one invalid
+// order doesn't hold back the rest.
+func transitionHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != "POST" {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
 	
+	var req struct {
+		IDs    []int  `json:"ids"`
+		Status string `json:"status"`
+	}
+	if !decodeBody(w, r, &req) {
+		return
+	}
+	if len(req.IDs) == 0 {
+		writeError(w, http.StatusUnprocessableEntity, "Invalid request")
+		return
+	}
+	req.Status = normalizeStatus(req.Status)
+	if !isValidStatus(req.Status) {
+		writeError(w, http.StatusUnprocessableEntity, "Invalid status")
+		return
+	}
+	
+	// The status each order moved from, in the order they succeeded; an ID
+	// listed twice moves twice.
+	from := make([]string, 0, len(req.IDs))
+	updated, errs, err := store.UpdateMany(r.Context(), req.IDs, func(order *Order) error {
+		if order.DeletedAt != nil {
+			return errOrderDeleted
+		}
+		if err := checkTransition(order.Status, req.Status); err != nil {
+			return err
+		}
+		from = append(from, order.Status)
+		order.Status = req.Status
+		recordChange(r, order, from[len(from)-1], order.Notes)
+		order.Version++
+		return nil
+	})
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	
+	results := make([]transitionResult, len(req.IDs))
+	succeeded := 0
+	for i, id := range req.IDs {
+		results[i].ID = id
+		switch {
+		case errors.Is(errs[i], errOrderNotFound):
+			results[i].Error, results[i].Code = errs[i].Error(), "order_not_found"
+		case errors.Is(errs[i], errOrderDeleted):
+			results[i].Error, results[i].Code = errs[i].Error(), "order_deleted"
+		case errs[i] != nil:
+			results[i].Error, results[i].Code = errs[i].Error(), "invalid_transition"
+		default:
+			results[i].Success = true
+			results[i].Status = updated[i].Status
+			succeeded++
+			notifyWebhook(updateEvent(from[succeeded-1], updated[i]), updated[i])
+			atomic.AddUint64(&ordersUpdatedTotal, 1)
+		}
+	}
+	
+	logEvent(r, "debug", "Orders transitioned", "status", req.Status, "succeeded", succeeded, "failed", len(req.IDs)-succeeded)
+	json.NewEncoder(w).Encode(Response{
+		Success: true,
+		Count:   counted(succeeded),
+		Data:    results,
+	})
+}
+
+// metricsHandler serves Prometheus text by default, or JSON when the client
+// asks for application/json.
+func metricsHandler(w http.ResponseWriter, r *http.Request) {
+	metrics, err := collectMetrics(r.Context())
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	if acceptsJSON(r) {
+		w.Header().Set("Content-Type", "application/json")
+		writeMetricsJSON(w, metrics)
+		return
+	}
 	w.Header().Set("Content-Type", "text/plain")
-	fmt.Fprintf(w, "# HELP orders_total Total orders\n")
-	fmt.Fprintf(w, "# TYPE orders_total gauge\n")
-	fmt.Fprintf(w, "orders_total %d\n", count)
-	fmt.Fprintf(w, "\n# HELP app_uptime_seconds Application uptime\n")
-	fmt.Fprintf(w, "# TYPE app_uptime_seconds gauge\n")
-	fmt.Fprintf(w, "app_uptime_seconds %.2f\n", time.Since(startTime).Seconds())
+	writePrometheus(w, metrics)
+}
+
+func acceptsJSON(r *http.Request) bool {
+	for _, part := range strings.Split(r.Header.Get("Accept"), ",") {
+		if mediaType, _, err := mime.ParseMediaType(part); err == nil && mediaType == "application/json" {
+			return true
+		}
+	}
+	return false
 }
 
 func rootHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
+	endpoints := map[string]string{
+		"health":     "/health (liveness: process is up, stays 200 while draining)",
+		"ready":      "/ready (readiness: 503 until the store is loaded, during shutdown, or while a critical dependency check fails)",
+		"orders":     "/api/orders",
+		"order":      "/api/orders/{id} (GET, PUT replaces the whole order or creates it with ?upsert=true, PATCH applies a JSON merge patch of status, notes and quantity, DELETE soft-deletes unless ?hard=true)",
+		"number":     "/api/orders/number/{order_number}",
+		"restore":    "/api/orders/{id}/restore (POST)",
+		"cancel":     "/api/orders/{id}/cancel (POST, pending or processing only)",
+		"history":    "/api/orders/{id}/history",
+		"tags":       "/api/orders/{id}/tags (POST adds, DELETE removes {\"tags\": [...]}); filter lists with ?tag=",
+		"bulk":       "/api/orders/bulk (DELETE with {\"ids\": [...]})",
+		"transition": "/api/orders/transition (POST with {\"ids\": [...], \"status\": \"...\"})",
+		"search":     "/api/orders/search?q=...",
+		"query":      "/api/orders/query (POST with a JSON filter)",
+		"summary":    "/api/orders/summary",
+		"products":   "/api/products",
+		"product":    "/api/products/{id}",
+		"metrics":    metricsPath,
+		"version":    "/version",
+	}
+	if metricsPort != "" {
+		delete(endpoints, "metrics")
+	}
+	for name, path := range endpoints {
+		endpoints[name] = pathPrefix + path
+	}
 	json.NewEncoder(w).Encode(map[string]interface{}{
-		"service": "Order API",
-		"version": "1.0.0",
-		"endpoints": map[string]string{
-			"health":  "/health",
-			"ready":   "/ready",
-			"orders":  "/api/orders",
-			"metrics": "/metrics",
-		},
+		"service":   "Order API",
+		"version":   version,
+		"endpoints": endpoints,
 	})
 }
 
+// notFoundHandler answers 404 for paths that must not fall through to
+// rootHandler, like disabled endpoints.
+func notFoundHandler(w http.ResponseWriter, r *http.Request) {
+	writeError(w, http.StatusNotFound, "Not found")
+}
+
+// writeError writes the JSON error envelope every handler uses, with a code
+// derived from the status.
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeErrorCode(w, status, errorCode(status), msg)
+}
+
+// writeErrorCode is writeError with a specific machine-readable code, for
+// errors clients are expected to branch on.
+func writeErrorCode(w http.ResponseWriter, status int, code, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(Response{Success: false, Error: msg, Code: code})
+}
+
+// errorCode derives a generic code from the status text, e.g. "not_found".
+func errorCode(status int) string {
+	return strings.ReplaceAll(strings.ToLower(http.StatusText(status)), " ", "_")
+}
+
+// headWriter swallows the body of a HEAD response, counting it so the
+// Content-Length matches what GET would have sent.
+type headWriter struct {
+	http.ResponseWriter
+	status int
+	n      int
+}
+
+func (h *headWriter) WriteHeader(code int) {
+	if h.status == 0 {
+		h.status = code
+	}
+}
+
+func (h *headWriter) Write(p []byte) (int, error) {
+	if h.status == 0 {
+		h.status = http.StatusOK
+	}
+	h.n += len(p)
+	return len(p), nil
+}
+
+// serveHead answers a HEAD request by running get, the resource's GET
+// handler, and sending only its status and headers.
+func serveHead(w http.ResponseWriter, get func(w http.ResponseWriter)) {
+	hw := &headWriter{ResponseWriter: w}
+	get(hw)
+	if hw.status == 0 {
+		hw.status = http.StatusOK
+	}
+	if hw.status != http.StatusNotModified {
+		w.Header().Set("Content-Length", strconv.Itoa(hw.n))
+	}
+	w.WriteHeader(hw.status)
+}
+
+func minTime(a, b time.Time) time.Time {
+	if a.Before(b) {
+		return a
+	}
+	return b
+}
+
+// emptyIfNil makes list responses encode as [] rather than null when a code
+// path ends up with a nil slice; strict clients reject null for arrays.
+func emptyIfNil[T any](list []T) []T {
+	if list == nil {
+		return []T{}
+	}
+	return list
+}
+
+// decodeBody decodes the JSON request body into v, writing the error
+// response itself and reporting false if the body is unusable: 400 if it is
+// not JSON at all, 422 if it is JSON of the wrong shape.
+func decodeBody(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	return decodeBodyAs(w, r, v, "application/json")
+}
+
+// decodeBodyAs is decodeBody for a body that may be any of mediaTypes, the
+// first being the preferred one.
+func decodeBodyAs(w http.ResponseWriter, r *http.Request, v interface{}, mediaTypes ...string) bool {
+	return decodeValidBody(w, r, v, nil, mediaTypes...)
+}
+
+// decodeValidBody is decodeBodyAs that first checks the body against schema,
+// when it is not nil, reporting all its violations rather than the first.
+func decodeValidBody(w http.ResponseWriter, r *http.Request, v interface{}, schema *jsonSchema, mediaTypes ...string) bool {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || !slices.Contains(mediaTypes, mediaType) {
+		writeError(w, http.StatusUnsupportedMediaType, "Content-Type must be "+strings.Join(mediaTypes, " or "))
+		return false
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBodyBytes))
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("Request body exceeds %d bytes", tooLarge.Limit))
+			return false
+		}
+		writeError(w, http.StatusBadRequest, "Invalid request")
+		return false
+	}
+	if schema != nil {
+		var doc interface{}
+		dec := json.NewDecoder(bytes.NewReader(body))
+		dec.UseNumber()
+		if err := dec.Decode(&doc); err != nil {
+			writeError(w, http.StatusBadRequest, "Invalid request")
+			return false
+		}
+		if violations := schema.validate("", doc); len(violations) > 0 {
+			writeViolations(w, violations)
+			return false
+		}
+	}
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		var syntaxErr *json.SyntaxError
+		var typeErr *json.UnmarshalTypeError
+		switch {
+		case errors.As(err, &syntaxErr), errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+			writeError(w, http.StatusBadRequest, "Invalid request")
+		case errors.As(err, &typeErr) && typeErr.Field != "":
+			writeError(w, http.StatusUnprocessableEntity, "Invalid type for field "+typeErr.Field)
+		default:
+			if field, ok := strings.CutPrefix(err.Error(), "json: unknown field "); ok {
+				writeError(w, http.StatusUnprocessableEntity, "Unknown field "+field)
+				return false
+			}
+			writeError(w, http.StatusUnprocessableEntity, "Invalid request")
+		}
+		return false
+	}
+	return true
+}
+
+func queryBool(r *http.Request, key string) bool {
+	v, _ := strconv.ParseBool(r.URL.Query().Get(key))
+	return v
+}
+
+func queryInt(r *http.Request, key string, fallback int) (int, bool) {
+	raw := r.URL.Query().Get(key)
+	if raw == "" {
+		return fallback, true
+	}
+	n, err := strconv.Atoi(raw)
+	if err != nil || n < 0 {
+		return 0, false
+	}
+	return n, true
+}
+
+// accessLogSkip returns the paths left out of the access log. Probes are
+// skipped unless LOG_PROBES is true.
+func accessLogSkip() map[string]bool {
+	if probes, _ := strconv.ParseBool(os.Getenv("LOG_PROBES")); probes {
+		return nil
+	}
+	return map[string]bool{"/health": true, "/ready": true}
+}
+
 func getEnv(key, fallback string) string {
 	if value := os.Getenv(key); value != "" {
 		return value
 	}
 	return fallback
 }
+
+func getDurationEnv(key string, fallback time.Duration) time.Duration {
+	raw := os.Getenv(key)
+	if raw == "" {
+		return fallback
+	}
+	d, err := time.ParseDuration(raw)
+	if err != nil || d < 0 {
+		log.Fatalf("Invalid %s %q: must be a duration like 15s", key, raw)
+	}
+	return d
+}
@@ -0,0 +1,312 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	initProducts()
+	os.Exit(m.Run())
+}
+
+func TestValidateOrder(t *testing.T) {
+	tests := []struct {
+		name    string
+		order   Order
+		wantErr string
+	}{
+		{"valid", Order{CustomerID: 1, ProductID: 1, Quantity: 1}, ""},
+		{"zero total", Order{CustomerID: 1, ProductID: 1, Quantity: 1, Total: 0}, ""},
+		{"zero quantity", Order{CustomerID: 1, ProductID: 1, Quantity: 0}, "Missing required fields"},
+		{"negative quantity", Order{CustomerID: 1, ProductID: 1, Quantity: -1}, "Invalid quantity -1"},
+		{"negative total", Order{CustomerID: 1, ProductID: 1, Quantity: 1, Total: -1}, "Invalid total -0.01"},
+		{"zero customer", Order{ProductID: 1, Quantity: 1}, "Missing required fields"},
+		{"zero item quantity", Order{CustomerID: 1, Items: []LineItem{{ProductID: 1}}}, "Missing required fields in item 0"},
+		{"negative item quantity", Order{CustomerID: 1, Items: []LineItem{{ProductID: 1, Quantity: -2}}}, "Invalid quantity -2 in item 0"},
+		{"valid items", Order{CustomerID: 1, Items: []LineItem{{ProductID: 1, Quantity: 1}, {ProductID: 2, Quantity: 3}}}, ""},
+		{"negative customer", Order{CustomerID: -5, ProductID: 1, Quantity: 1}, "Invalid customer_id -5: must be positive"},
+		{"negative product", Order{CustomerID: 1, ProductID: -5, Quantity: 1}, "Invalid product_id -5: must be positive"},
+		{"negative customer with items", Order{CustomerID: -1, Items: []LineItem{{ProductID: 1, Quantity: 1}}}, "Invalid customer_id -1"},
+		{"negative item product", Order{CustomerID: 1, Items: []LineItem{{ProductID: -3, Quantity: 1}}}, "Invalid product_id -3 in item 0"},
+		{"quantity at max", Order{CustomerID: 1, ProductID: 1, Quantity: maxQuantity}, ""},
+		{"quantity over max", Order{CustomerID: 1, ProductID: 1, Quantity: maxQuantity + 1}, "Invalid quantity 10001: must be between 1 and 10000"},
+		{"item quantities at max", Order{CustomerID: 1, Items: []LineItem{{ProductID: 1, Quantity: maxQuantity - 1}, {ProductID: 2, Quantity: 1}}}, ""},
+		{"item quantities over max", Order{CustomerID: 1, Items: []LineItem{{ProductID: 1, Quantity: maxQuantity}, {ProductID: 2, Quantity: 1}}}, "Invalid quantity 10001 across items"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateOrder(&tt.order)
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Fatalf("validateOrder() = %v, want nil", err)
+			case tt.wantErr != "" && err == nil:
+				t.Fatalf("validateOrder() = nil, want error containing %q", tt.wantErr)
+			case tt.wantErr != "" && !strings.Contains(err.Error(), tt.wantErr):
+				t.Fatalf("validateOrder() = %v, want error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// useTestStore swaps in an empty in-memory store for the length of the test.
+func useTestStore(t *testing.T) *memoryStore {
+	t.Helper()
+	s := newMemoryStore("")
+	prev := store
+	store = s
+	t.Cleanup(func() { store = prev })
+	return s
+}
+
+// jsonRequest builds a request with a JSON body.
+func jsonRequest(method, target, body string) *http.Request {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	return req
+}
+
+func TestDecodeValidBodyTooLarge(t *testing.T) {
+	prev := maxBodyBytes
+	maxBodyBytes = 64
+	t.Cleanup(func() { maxBodyBytes = prev })
+
+	body := `{"customer_id":1,"product_id":1,"quantity":1,"notes":"` + strings.Repeat("x", 100) + `"}`
+	rec := httptest.NewRecorder()
+	var o Order
+	if decodeValidBody(rec, jsonRequest("POST", "/api/orders", body), &o, orderSchema, "application/json") {
+		t.Fatal("decodeValidBody accepted an oversized body")
+	}
+	if rec.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusRequestEntityTooLarge)
+	}
+	if !strings.Contains(rec.Body.String(), "64 bytes") {
+		t.Fatalf("body %s does not name the limit", rec.Body)
+	}
+}
+
+func TestDecodeBodyUnknownFields(t *testing.T) {
+	t.Run("valid payload decodes", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		var o Order
+		if !decodeValidBody(rec, jsonRequest("POST", "/api/orders", `{"customer_id":7,"product_id":2,"quantity":3}`), &o, orderSchema, "application/json") {
+			t.Fatalf("decodeValidBody rejected a valid payload: %d %s", rec.Code, rec.Body)
+		}
+		if o.CustomerID != 7 || o.ProductID != 2 || o.Quantity != 3 {
+			t.Fatalf("decoded %+v", o)
+		}
+	})
+	t.Run("extra field is rejected", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		var q orderQuery
+		if decodeBody(rec, jsonRequest("POST", "/api/orders/query", `{"quanttiy":5}`), &q) {
+			t.Fatal("decodeBody accepted an unknown field")
+		}
+		if !strings.Contains(rec.Body.String(), `Unknown field \"quanttiy\"`) {
+			t.Fatalf("body %s does not name the field", rec.Body)
+		}
+	})
+	t.Run("extra field fails the schema", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		var o Order
+		if decodeValidBody(rec, jsonRequest("POST", "/api/orders", `{"customer_id":7,"product_id":2,"quanttiy":3}`), &o, orderSchema, "application/json") {
+			t.Fatal("decodeValidBody accepted an unknown field")
+		}
+		if !strings.Contains(rec.Body.String(), `"field":"/quanttiy","message":"is not an allowed field"`) {
+			t.Fatalf("body %s does not name the field", rec.Body)
+		}
+	})
+}
+
+func TestEmptyListsEncodeAsArrays(t *testing.T) {
+	useTestStore(t)
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		req     *http.Request
+	}{
+		{"list", ordersHandler, httptest.NewRequest("GET", "/api/orders", nil)},
+		{"search", searchOrdersHandler, httptest.NewRequest("GET", "/api/orders/search?q=laptop", nil)},
+		{"query", queryOrdersHandler, jsonRequest("POST", "/api/orders/query", `{}`)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			tt.handler(rec, tt.req)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d, body %s", rec.Code, rec.Body)
+			}
+			if !strings.Contains(rec.Body.String(), `"data":[]`) {
+				t.Fatalf("body %s lacks \"data\":[]", rec.Body)
+			}
+		})
+	}
+}
+
+func TestCreateOrderLocation(t *testing.T) {
+	useTestStore(t)
+	rec := httptest.NewRecorder()
+	createOrder(rec, jsonRequest("POST", "/api/orders", `{"customer_id":1,"product_id":2,"quantity":1}`))
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("status = %d, body %s", rec.Code, rec.Body)
+	}
+	var created struct{ Data Order }
+	if err := json.Unmarshal(rec.Body.Bytes(), &created); err != nil {
+		t.Fatal(err)
+	}
+
+	loc, err := url.Parse(rec.Header().Get("Location"))
+	if err != nil || loc.Path == "" {
+		t.Fatalf("Location %q is not a URL: %v", rec.Header().Get("Location"), err)
+	}
+	if want := "/api/orders/number/" + created.Data.OrderNumber; loc.Path != want {
+		t.Fatalf("Location = %q, want %q", loc.Path, want)
+	}
+	rec = httptest.NewRecorder()
+	orderByNumberHandler(rec, httptest.NewRequest("GET", loc.String(), nil))
+	var fetched struct{ Data Order }
+	if rec.Code != http.StatusOK || json.Unmarshal(rec.Body.Bytes(), &fetched) != nil || fetched.Data.ID != created.Data.ID {
+		t.Fatalf("GET %s: status = %d, body %s", loc, rec.Code, rec.Body)
+	}
+}
+
+func TestUpsertCreatesAtInitialStatus(t *testing.T) {
+	s := useTestStore(t)
+	for _, status := range []string{"", statusCompleted, statusCancelled, statusExpired} {
+		body := `{"customer_id":1,"product_id":2,"quantity":1,"status":"` + status + `"}`
+		if status == "" {
+			body = `{"customer_id":1,"product_id":2,"quantity":1}`
+		}
+		rec := httptest.NewRecorder()
+		replaceOrder(rec, jsonRequest("PUT", "/api/orders/5?upsert=true", body), 5)
+		if rec.Code != http.StatusCreated {
+			t.Fatalf("upsert with status %q: status = %d, body %s", status, rec.Code, rec.Body)
+		}
+		o, err := s.Get(context.Background(), 5)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if o.Status != initialStatus {
+			t.Errorf("upsert with status %q created a %s order", status, o.Status)
+		}
+		s.seed(nil)
+	}
+}
+
+func TestMalformedVersusInvalidBodies(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		method  string
+		target  string
+		body    string
+		want    int
+	}{
+		{"create: malformed", ordersHandler, "POST", "/api/orders", `{"customer_id":1,`, http.StatusBadRequest},
+		{"create: empty body", ordersHandler, "POST", "/api/orders", ``, http.StatusBadRequest},
+		{"create: missing fields", ordersHandler, "POST", "/api/orders", `{"customer_id":1}`, http.StatusUnprocessableEntity},
+		{"create: negative quantity", ordersHandler, "POST", "/api/orders", `{"customer_id":1,"product_id":1,"quantity":-1}`, http.StatusUnprocessableEntity},
+		{"create: over max quantity", ordersHandler, "POST", "/api/orders", `{"customer_id":1,"product_id":1,"quantity":10001}`, http.StatusUnprocessableEntity},
+		{"create: unknown product", ordersHandler, "POST", "/api/orders", `{"customer_id":1,"product_id":99,"quantity":1}`, http.StatusUnprocessableEntity},
+		{"replace: malformed", orderHandler, "PUT", "/api/orders/2", `{`, http.StatusBadRequest},
+		{"replace: wrong type", orderHandler, "PUT", "/api/orders/2", `{"customer_id":"one","product_id":1,"quantity":1}`, http.StatusUnprocessableEntity},
+		{"update: malformed", orderHandler, "PATCH", "/api/orders/2", `{"status":`, http.StatusBadRequest},
+		{"update: bad transition", orderHandler, "PATCH", "/api/orders/1", `{"status":"pending"}`, http.StatusUnprocessableEntity},
+		{"update: invalid status", orderHandler, "PATCH", "/api/orders/2", `{"status":"lost"}`, http.StatusUnprocessableEntity},
+		{"cancel: terminal order", orderHandler, "POST", "/api/orders/1/cancel", ``, http.StatusUnprocessableEntity},
+		{"tags: malformed", orderHandler, "POST", "/api/orders/2/tags", `{"tags":[`, http.StatusBadRequest},
+		{"tags: none given", orderHandler, "POST", "/api/orders/2/tags", `{"tags":[]}`, http.StatusUnprocessableEntity},
+		{"query: malformed", queryOrdersHandler, "POST", "/api/orders/query", `[1,`, http.StatusBadRequest},
+		{"query: unknown field", queryOrdersHandler, "POST", "/api/orders/query", `{"colour":"red"}`, http.StatusUnprocessableEntity},
+		{"bulk delete: no IDs", bulkDeleteHandler, "DELETE", "/api/orders/bulk", `{"ids":[]}`, http.StatusUnprocessableEntity},
+		{"transition: malformed", transitionHandler, "POST", "/api/orders/transition", `{"ids":[1]`, http.StatusBadRequest},
+		{"transition: invalid status", transitionHandler, "POST", "/api/orders/transition", `{"ids":[2],"status":"lost"}`, http.StatusUnprocessableEntity},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useTestStore(t).seed([]Order{
+				{ID: 1, CustomerID: 1, ProductID: 1, Quantity: 1, Status: statusCompleted, Version: 1, CreatedAt: now()},
+				{ID: 2, CustomerID: 1, ProductID: 1, Quantity: 1, Status: statusPending, Version: 1, CreatedAt: now()},
+			})
+			rec := httptest.NewRecorder()
+			tt.handler(rec, jsonRequest(tt.method, tt.target, tt.body))
+			if rec.Code != tt.want {
+				t.Fatalf("status = %d, want %d; body %s", rec.Code, tt.want, rec.Body)
+			}
+		})
+	}
+}
+
+func TestEveryCancelStampsCancelledAt(t *testing.T) {
+	tests := []struct {
+		name string
+		call func(w http.ResponseWriter)
+	}{
+		{"cancel", func(w http.ResponseWriter) {
+			cancelOrder(w, httptest.NewRequest("POST", "/api/orders/1/cancel", nil), 1)
+		}},
+		{"patch", func(w http.ResponseWriter) {
+			updateOrder(w, jsonRequest("PATCH", "/api/orders/1", `{"status":"cancelled"}`), 1)
+		}},
+		{"put", func(w http.ResponseWriter) {
+			replaceOrder(w, jsonRequest("PUT", "/api/orders/1", `{"customer_id":1,"product_id":1,"quantity":1,"status":"cancelled"}`), 1)
+		}},
+		{"transition", func(w http.ResponseWriter) {
+			transitionHandler(w, jsonRequest("POST", "/api/orders/transition", `{"ids":[1],"status":"cancelled"}`))
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := useTestStore(t)
+			s.seed([]Order{{ID: 1, CustomerID: 1, ProductID: 1, Quantity: 1, Status: statusPending, Version: 1}})
+			rec := httptest.NewRecorder()
+			tt.call(rec)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d, body %s", rec.Code, rec.Body)
+			}
+			o, err := s.Get(context.Background(), 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if o.Status != statusCancelled || o.CancelledAt == nil {
+				t.Fatalf("status %s, cancelled_at %v", o.Status, o.CancelledAt)
+			}
+			if got := updateEvent(statusPending, o); got != eventOrderCancelled {
+				t.Fatalf("updateEvent = %s, want %s", got, eventOrderCancelled)
+			}
+		})
+	}
+}
+
+func TestPaginationLinksHugeOffset(t *testing.T) {
+	r := httptest.NewRequest("GET", "/api/orders", nil)
+	links := paginationLinks(r, math.MaxInt, 10, 25)
+	if strings.Contains(links, `rel="next"`) {
+		t.Fatalf("next link past the end: %s", links)
+	}
+	if !strings.Contains(links, `offset=20`) || !strings.Contains(links, `rel="last"`) {
+		t.Fatalf("last link missing: %s", links)
+	}
+	if links := paginationLinks(r, 10, 10, 25); !strings.Contains(links, `offset=20>; rel="next"`) {
+		t.Fatalf("next link missing mid-list: %s", links)
+	}
+}
+
+func TestMatchesSearchLineItems(t *testing.T) {
+	o := &Order{ID: 1, CustomerID: 2, Status: statusPending, Items: []LineItem{
+		{ProductID: 3, Quantity: 1},
+		{ProductID: 45, Quantity: 2},
+	}}
+	for q, want := range map[string]bool{"3": true, "4": true, "45": true, "6": false, "pending": true} {
+		if got := matchesSearch(o, q); got != want {
+			t.Errorf("matchesSearch(%q) = %t, want %t", q, got, want)
+		}
+	}
+}
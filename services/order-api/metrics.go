@@ -0,0 +1,317 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// metricsPath is where metricsHandler is served; it is set from METRICS_PATH.
+var metricsPath = "/metrics"
+
+// metricsPort, set from METRICS_PORT, moves metricsPath off the API's
+// listener onto its own.
+var metricsPort string
+
+var durationBuckets = []float64{0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1}
+
+type durationKey struct {
+	method string
+	route  string
+}
+
+type histogram struct {
+	buckets []uint64
+	count   uint64
+	sum     float64
+}
+
+var (
+	durationsMutex   sync.Mutex
+	requestDurations = make(map[durationKey]*histogram)
+)
+
+func observeDuration(key durationKey, seconds float64) {
+	durationsMutex.Lock()
+	defer durationsMutex.Unlock()
+
+	h, ok := requestDurations[key]
+	if !ok {
+		h = &histogram{buckets: make([]uint64, len(durationBuckets))}
+		requestDurations[key] = h
+	}
+	for i, le := range durationBuckets {
+		if seconds <= le {
+			h.buckets[i]++
+		}
+	}
+	h.count++
+	h.sum += seconds
+}
+
+func recordDuration(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := routeLabel(r)
+		if route == metricsPath {
+			next.ServeHTTP(w, r)
+			return
+		}
+		start := time.Now()
+		next.ServeHTTP(w, r)
+		observeDuration(durationKey{method: r.Method, route: route}, time.Since(start).Seconds())
+	})
+}
+
+// routeLabel maps a request onto its registered pattern so that order IDs
+// don't blow up the label cardinality.
+func routeLabel(r *http.Request) string {
+	_, pattern := http.DefaultServeMux.Handler(r)
+	switch pattern {
+	case "/api/orders/":
+		return "/api/orders/{id}"
+	case "/api/orders/number/":
+		return "/api/orders/number/{number}"
+	case "/api/products/":
+		return "/api/products/{id}"
+	}
+	return pattern
+}
+
+func durationMetrics() metric {
+	durationsMutex.Lock()
+	defer durationsMutex.Unlock()
+
+	keys := make([]durationKey, 0, len(requestDurations))
+	for k := range requestDurations {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].route != keys[j].route {
+			return keys[i].route < keys[j].route
+		}
+		return keys[i].method < keys[j].method
+	})
+
+	m := metric{
+		Name: "http_request_duration_seconds",
+		Help: "Request latency by method and route",
+		Type: "histogram",
+	}
+	for _, k := range keys {
+		h := requestDurations[k]
+		for i, le := range durationBuckets {
+			m.add("_bucket", float64(h.buckets[i]), "method", k.method, "route", k.route, "le", strconv.FormatFloat(le, 'g', -1, 64))
+		}
+		m.add("_bucket", float64(h.count), "method", k.method, "route", k.route, "le", "+Inf")
+		m.add("_sum", h.sum, "method", k.method, "route", k.route)
+		m.add("_count", float64(h.count), "method", k.method, "route", k.route)
+	}
+	return m
+}
+
+// Monotonic mutation counters, so rates can be taken with rate() instead of
+// differencing the orders_total gauge. Cancels and restores count as updates.
+var ordersCreatedTotal, ordersUpdatedTotal, ordersDeletedTotal uint64
+
+func counter(name, help string, value *uint64) metric {
+	m := metric{Name: name, Help: help, Type: "counter"}
+	m.add("", float64(atomic.LoadUint64(value)))
+	return m
+}
+
+// listLimitBuckets label orders_list_requests by the page size asked for,
+// after clamping to maxLimit.
+var listLimitBuckets = []struct {
+	label string
+	max   int
+}{
+	{"0", 0},
+	{"1-50", 50},
+	{"51-100", 100},
+	{"101-250", 250},
+	{"251-500", 500},
+}
+
+var listRequests = make([]uint64, len(listLimitBuckets))
+
+func observeListLimit(limit int) {
+	for i, b := range listLimitBuckets {
+		if limit <= b.max {
+			atomic.AddUint64(&listRequests[i], 1)
+			return
+		}
+	}
+}
+
+func listMetrics() metric {
+	m := metric{
+		Name: "orders_list_requests",
+		Help: "Order list requests by requested page size",
+		Type: "counter",
+	}
+	for i, b := range listLimitBuckets {
+		m.add("", float64(atomic.LoadUint64(&listRequests[i])), "limit_bucket", b.label)
+	}
+	return m
+}
+
+// metric is one metric family as served by /metrics, in either format.
+type metric struct {
+	Name    string   `json:"-"`
+	Help    string   `json:"help"`
+	Type    string   `json:"type"`
+	Samples []sample `json:"samples"`
+}
+
+// sample is one value of a metric. Labels keeps name/value pairs in order
+// so the text format is stable.
+type sample struct {
+	Name   string   `json:"name"`
+	Labels []string `json:"-"`
+	Value  float64  `json:"value"`
+}
+
+func (s sample) MarshalJSON() ([]byte, error) {
+	labels := make(map[string]string, len(s.Labels)/2)
+	for i := 0; i+1 < len(s.Labels); i += 2 {
+		labels[s.Labels[i]] = s.Labels[i+1]
+	}
+	return json.Marshal(struct {
+		Name   string            `json:"name"`
+		Labels map[string]string `json:"labels,omitempty"`
+		Value  float64           `json:"value"`
+	}{s.Name, labels, s.Value})
+}
+
+// add appends a sample named after the metric plus suffix, e.g. "_bucket".
+func (m *metric) add(suffix string, value float64, labels ...string) {
+	m.Samples = append(m.Samples, sample{Name: m.Name + suffix, Labels: labels, Value: value})
+}
+
+func gauge(name, help string, value float64) metric {
+	m := metric{Name: name, Help: help, Type: "gauge"}
+	m.add("", value)
+	return m
+}
+
+// collectMetrics gathers everything /metrics reports, so the text and JSON
+// formats are always built from the same values.
+func collectMetrics(ctx context.Context) ([]metric, error) {
+	all, err := store.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	count, recent := 0, 0
+	recentSince := now().Add(-recentWindow)
+	byStatus := make(map[string]int, len(validStatuses))
+	var revenueCompleted, revenuePending Money
+	for _, o := range all {
+		if o.DeletedAt != nil {
+			continue
+		}
+		count++
+		if o.CreatedAt.After(recentSince) {
+			recent++
+		}
+		byStatus[o.Status]++
+		switch o.Status {
+		case statusCompleted:
+			revenueCompleted += o.Total
+		case statusPending:
+			revenuePending += o.Total
+		}
+	}
+
+	statuses := metric{Name: "orders_by_status", Help: "Orders by status", Type: "gauge"}
+	for _, status := range validStatuses {
+		statuses.add("", float64(byStatus[status]), "status", status)
+	}
+	metrics := []metric{
+		gauge("orders_total", "Total orders", float64(count)),
+		statuses,
+		gauge("orders_recent_total", fmt.Sprintf("Orders created in the last %s", recentWindow), float64(recent)),
+		gauge("orders_revenue_total", "Sum of completed order totals", revenueCompleted.Float()),
+		gauge("orders_revenue_pending", "Sum of pending order totals", revenuePending.Float()),
+		counter("orders_created_total", "Orders created", &ordersCreatedTotal),
+		counter("orders_updated_total", "Order updates, including cancels and restores", &ordersUpdatedTotal),
+		counter("orders_deleted_total", "Orders deleted", &ordersDeletedTotal),
+		counter("orders_expired_total", "Pending orders expired after PENDING_TTL", &ordersExpiredTotal),
+		listMetrics(),
+		counter("orders_list_cache_hits_total", "Order list responses served from LIST_CACHE_TTL cache", &listCacheHits),
+		counter("orders_list_cache_misses_total", "Order list requests the cache could not answer", &listCacheMisses),
+		gauge("app_uptime_seconds", "Application uptime", roundCents(time.Since(startTime).Seconds())),
+		durationMetrics(),
+	}
+	if exposeRuntimeMetrics {
+		metrics = append(metrics, runtimeMetrics()...)
+	}
+	return metrics, nil
+}
+
+// recentWindow is how far back orders_recent_total looks; it is set from
+// RECENT_WINDOW.
+var recentWindow = 5 * time.Minute
+
+// exposeRuntimeMetrics adds Go runtime stats to /metrics; it is set from
+// EXPOSE_RUNTIME_METRICS.
+var exposeRuntimeMetrics = true
+
+// runtimeMetrics reports process resource usage so request spikes can be
+// correlated with memory and GC pressure. ReadMemStats briefly stops the
+// world, which is fine at scrape frequency.
+func runtimeMetrics() []metric {
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+
+	gc := metric{Name: "go_gc_duration_seconds", Help: "Time spent in GC stop-the-world pauses", Type: "summary"}
+	gc.add("_sum", float64(ms.PauseTotalNs)/1e9)
+	gc.add("_count", float64(ms.NumGC))
+	return []metric{
+		gauge("go_goroutines", "Number of goroutines that currently exist", float64(runtime.NumGoroutine())),
+		gauge("go_memstats_alloc_bytes", "Bytes of allocated heap objects", float64(ms.Alloc)),
+		gauge("go_memstats_sys_bytes", "Bytes of memory obtained from the OS", float64(ms.Sys)),
+		gauge("go_memstats_heap_objects", "Number of allocated heap objects", float64(ms.HeapObjects)),
+		gauge("go_memstats_last_gc_time_seconds", "Time of the last garbage collection since the epoch", float64(ms.LastGC)/1e9),
+		gc,
+	}
+}
+
+// writePrometheus renders metrics in the Prometheus text exposition format.
+func writePrometheus(w io.Writer, metrics []metric) {
+	for i, m := range metrics {
+		if i > 0 {
+			fmt.Fprintln(w)
+		}
+		fmt.Fprintf(w, "# HELP %s %s\n", m.Name, m.Help)
+		fmt.Fprintf(w, "# TYPE %s %s\n", m.Name, m.Type)
+		for _, s := range m.Samples {
+			var labels []string
+			for j := 0; j+1 < len(s.Labels); j += 2 {
+				labels = append(labels, fmt.Sprintf("%s=%q", s.Labels[j], s.Labels[j+1]))
+			}
+			name := s.Name
+			if len(labels) > 0 {
+				name += "{" + strings.Join(labels, ",") + "}"
+			}
+			fmt.Fprintf(w, "%s %s\n", name, strconv.FormatFloat(s.Value, 'f', -1, 64))
+		}
+	}
+}
+
+// writeMetricsJSON renders metrics as one JSON object keyed by metric name.
+func writeMetricsJSON(w io.Writer, metrics []metric) {
+	byName := make(map[string]metric, len(metrics))
+	for _, m := range metrics {
+		byName[m.Name] = m
+	}
+	json.NewEncoder(w).Encode(byName)
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func seedMetricsStore(t *testing.T) {
+	t.Helper()
+	useTestStore(t).seed([]Order{
+		{ID: 1, CustomerID: 1, ProductID: 1, Quantity: 1, Total: 1050, Status: statusCompleted, CreatedAt: now()},
+		{ID: 2, CustomerID: 1, ProductID: 1, Quantity: 1, Total: 250, Status: statusPending, CreatedAt: now()},
+	})
+}
+
+func TestMetricsPrometheus(t *testing.T) {
+	seedMetricsStore(t)
+	rec := httptest.NewRecorder()
+	metricsHandler(rec, httptest.NewRequest("GET", "/metrics", nil))
+
+	if got := rec.Header().Get("Content-Type"); got != "text/plain" {
+		t.Fatalf("Content-Type = %q, want text/plain", got)
+	}
+	body := rec.Body.String()
+	for _, want := range []string{
+		"# TYPE orders_total gauge\norders_total 2\n",
+		`orders_by_status{status="completed"} 1`,
+		"orders_revenue_total 10.5\n",
+		"orders_revenue_pending 2.5\n",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("body lacks %q:\n%s", want, body)
+		}
+	}
+}
+
+func TestMetricsJSON(t *testing.T) {
+	seedMetricsStore(t)
+	req := httptest.NewRequest("GET", "/metrics", nil)
+	req.Header.Set("Accept", "application/json")
+	rec := httptest.NewRecorder()
+	metricsHandler(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d", rec.Code)
+	}
+	if got := rec.Header().Get("Content-Type"); got != "application/json" {
+		t.Fatalf("Content-Type = %q, want application/json", got)
+	}
+	var got map[string]struct {
+		Type    string `json:"type"`
+		Samples []struct {
+			Value float64 `json:"value"`
+		} `json:"samples"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]float64{
+		"orders_total":           2,
+		"orders_revenue_total":   10.5,
+		"orders_revenue_pending": 2.5,
+	} {
+		m, ok := got[name]
+		if !ok || m.Type != "gauge" || len(m.Samples) != 1 || m.Samples[0].Value != want {
+			t.Errorf("%s = %+v, want one gauge sample of %v", name, m, want)
+		}
+	}
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"runtime/debug"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+type contextKey string
+
+const requestIDKey contextKey = "request_id"
+
+var inFlight int64
+
+func trackInFlight(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&inFlight, 1)
+		defer atomic.AddInt64(&inFlight, -1)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// pathPrefix is where an ingress mounts the service, e.g. "/orders-svc", set
+// from PATH_PREFIX. Links the service hands out include it.
+var pathPrefix string
+
+// stripPathPrefix removes pathPrefix from request paths so routing and the
+// handlers' own path parsing see the same paths as at the root. Paths
+// without the prefix are served as they are, which keeps in-cluster probes
+// on /health and /ready working.
+func stripPathPrefix(prefix string, next http.Handler) http.Handler {
+	if prefix == "" {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rest, ok := strings.CutPrefix(r.URL.Path, prefix)
+		if !ok || (rest != "" && rest[0] != '/') {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if rest == "" {
+			rest = "/"
+		}
+		r2 := r.Clone(r.Context())
+		r2.URL.Path = rest
+		r2.URL.RawPath = ""
+		next.ServeHTTP(w, r2)
+	})
+}
+
+const (
+	defaultCORSMethods = "GET, POST, PUT, PATCH, DELETE, OPTIONS"
+	defaultCORSHeaders = "Content-Type, Authorization, Idempotency-Key, X-API-Key, X-Request-ID"
+)
+
+type corsConfig struct {
+	origin      string
+	methods     string
+	headers     string
+	credentials bool
+}
+
+// cors sets the CORS headers from cfg. origin is a comma-separated allow
+// list; a single entry is sent as is, but since Access-Control-Allow-Origin
+// carries one origin, with several (or with credentials, where the wildcard
+// is not allowed) a matching request Origin is echoed back instead.
+func cors(cfg corsConfig, next http.Handler) http.Handler {
+	allowed := make(map[string]bool)
+	for _, o := range strings.Split(cfg.origin, ",") {
+		if o = strings.TrimSpace(o); o != "" {
+			allowed[o] = true
+		}
+	}
+	echo := cfg.credentials || len(allowed) > 1
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if echo {
+			w.Header().Add("Vary", "Origin")
+			if origin := r.Header.Get("Origin"); allowed[origin] {
+				w.Header().Set("Access-Control-Allow-Origin", origin)
+				if cfg.credentials {
+					w.Header().Set("Access-Control-Allow-Credentials", "true")
+				}
+			}
+		} else {
+			w.Header().Set("Access-Control-Allow-Origin", strings.TrimSpace(cfg.origin))
+		}
+		w.Header().Set("Access-Control-Allow-Methods", cfg.methods)
+		w.Header().Set("Access-Control-Allow-Headers", cfg.headers)
+		if r.Method == http.MethodOptions {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func withRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if id == "" {
+			id = newUUID()
+		}
+		w.Header().Set("X-Request-ID", id)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDKey, id)))
+	})
+}
+
+func requestID(r *http.Request) string {
+	id, _ := r.Context().Value(requestIDKey).(string)
+	return id
+}
+
+func newUUID() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic(err)
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
+
+func requireAPIKey(key string, next http.Handler) http.Handler {
+	if key == "" {
+		return next
+	}
+	want := sha256.Sum256([]byte(key))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if isMutating(r.Method) {
+			got := sha256.Sum256([]byte(r.Header.Get("X-API-Key")))
+			if subtle.ConstantTimeCompare(got[:], want[:]) != 1 {
+				writeError(w, http.StatusUnauthorized, "Invalid or missing API key")
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// requireBasicAuth guards next with HTTP basic auth, or leaves it open when
+// no user is configured.
+func requireBasicAuth(user, pass string, next http.Handler) http.Handler {
+	if user == "" {
+		return next
+	}
+	wantUser, wantPass := sha256.Sum256([]byte(user)), sha256.Sum256([]byte(pass))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotUser, gotPass, ok := r.BasicAuth()
+		u, p := sha256.Sum256([]byte(gotUser)), sha256.Sum256([]byte(gotPass))
+		userOK := subtle.ConstantTimeCompare(u[:], wantUser[:])
+		passOK := subtle.ConstantTimeCompare(p[:], wantPass[:])
+		if !ok || userOK&passOK != 1 {
+			w.Header().Set("WWW-Authenticate", `Basic realm="metrics", charset="UTF-8"`)
+			writeError(w, http.StatusUnauthorized, "Invalid or missing credentials")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// actor identifies who made a request for the audit history: a short
+// fingerprint of the API key, never the key itself, or "anonymous".
+func actor(r *http.Request) string {
+	key := r.Header.Get("X-API-Key")
+	if key == "" {
+		return "anonymous"
+	}
+	sum := sha256.Sum256([]byte(key))
+	return fmt.Sprintf("key:%x", sum[:4])
+}
+
+// waitForStore answers 503 until the store is loaded, except for the
+// probes, which report startup themselves.
+func waitForStore(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !storeReady.Load() && r.URL.Path != "/health" && r.URL.Path != "/ready" {
+			w.Header().Set("Retry-After", "1")
+			writeError(w, http.StatusServiceUnavailable, "Service is starting")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// bulkPaths get bulkTimeout instead of the default handler timeout, since
+// they touch many orders in one request.
+var bulkPaths = map[string]bool{
+	"/api/orders/bulk":       true,
+	"/api/orders/transition": true,
+	"/api/orders/query":      true,
+	"/api/admin/reset":       true,
+}
+
+// handlerTimeouts answers 503 for any request still running after timeout,
+// or bulkTimeout for bulkPaths, and cancels its context so store calls give
+// up. A zero timeout leaves those requests unbounded. TimeoutHandler buffers
+// each response, streamed order lists included; see streamOrders.
+func handlerTimeouts(timeout, bulkTimeout time.Duration, next http.Handler) http.Handler {
+	body, _ := json.Marshal(Response{Success: false, Error: "Request timed out", Code: errorCode(http.StatusServiceUnavailable)})
+	wrap := func(d time.Duration) http.Handler {
+		if d <= 0 {
+			return next
+		}
+		return http.TimeoutHandler(next, d, string(body))
+	}
+	short, long := wrap(timeout), wrap(bulkTimeout)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// TimeoutHandler drops the handler's headers when it times out, so
+		// its JSON body needs the type set up front.
+		w.Header().Set("Content-Type", "application/json")
+		if bulkPaths[r.URL.Path] {
+			long.ServeHTTP(w, r)
+			return
+		}
+		short.ServeHTTP(w, r)
+	})
+}
+
+func isMutating(method string) bool {
+	switch method {
+	case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+func recoverPanics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			v := recover()
+			if v == nil {
+				return
+			}
+			if v == http.ErrAbortHandler {
+				panic(v)
+			}
+			logEvent(r, "error", "Recovered from panic", "panic", fmt.Sprint(v), "stack", string(debug.Stack()))
+			writeError(w, http.StatusInternalServerError, "Internal server error")
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRequireAPIKey(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+	h := requireAPIKey("secret", ok)
+
+	tests := []struct {
+		name   string
+		method string
+		key    string
+		want   int
+	}{
+		{"missing key", "POST", "", http.StatusUnauthorized},
+		{"wrong key", "POST", "nope", http.StatusUnauthorized},
+		{"correct key", "POST", "secret", http.StatusNoContent},
+		{"wrong key on delete", "DELETE", "secrets", http.StatusUnauthorized},
+		{"correct key on put", "PUT", "secret", http.StatusNoContent},
+		{"get needs no key", "GET", "", http.StatusNoContent},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "/api/orders", nil)
+			if tt.key != "" {
+				req.Header.Set("X-API-Key", tt.key)
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+			if rec.Code != tt.want {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestRequireAPIKeyDisabled(t *testing.T) {
+	h := requireAPIKey("", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("POST", "/api/orders", nil))
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusNoContent)
+	}
+}
+
+func TestPrettyHeadMatchesGet(t *testing.T) {
+	get := func(w http.ResponseWriter) {
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `{"success":true,"data":{"id":1,"status":"pending"}}`)
+	}
+	srv := httptest.NewServer(prettyJSON(true, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodHead {
+			serveHead(w, get)
+			return
+		}
+		get(w)
+	})))
+	defer srv.Close()
+
+	lengths := make(map[string]int64)
+	for _, method := range []string{http.MethodGet, http.MethodHead} {
+		req, _ := http.NewRequest(method, srv.URL, nil)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		lengths[method] = resp.ContentLength
+	}
+	if lengths[http.MethodHead] != lengths[http.MethodGet] {
+		t.Fatalf("HEAD Content-Length = %d, GET = %d", lengths[http.MethodHead], lengths[http.MethodGet])
+	}
+}
+
+func TestCORSOrigins(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	tests := []struct {
+		name, origin, request string
+		credentials           bool
+		wantOrigin            string
+		wantVary              bool
+	}{
+		{"wildcard", "*", "https://a.example", false, "*", false},
+		{"single origin", "https://a.example", "https://b.example", false, "https://a.example", false},
+		{"list, listed origin", "https://a.example, https://b.example", "https://b.example", false, "https://b.example", true},
+		{"list, other origin", "https://a.example, https://b.example", "https://c.example", false, "", true},
+		{"credentials", "https://a.example", "https://a.example", true, "https://a.example", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := cors(corsConfig{origin: tt.origin, credentials: tt.credentials}, ok)
+			req := httptest.NewRequest("GET", "/api/orders", nil)
+			req.Header.Set("Origin", tt.request)
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+			if got := rec.Header().Get("Access-Control-Allow-Origin"); got != tt.wantOrigin {
+				t.Errorf("Access-Control-Allow-Origin = %q, want %q", got, tt.wantOrigin)
+			}
+			if got := rec.Header().Get("Vary") == "Origin"; got != tt.wantVary {
+				t.Errorf("Vary: Origin = %t, want %t", got, tt.wantVary)
+			}
+		})
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"strconv"
+)
+
+// Money is an amount in integer cents, so sums and comparisons are exact.
+// It is read and written in JSON as a decimal number of currency units,
+// e.g. 19.99, so the wire format is unchanged from plain floats.
+type Money int64
+
+// parseMoney reads a decimal amount like "19.99", rounding to the nearest
+// cent.
+func parseMoney(s string) (Money, error) {
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil || math.IsNaN(v) || math.IsInf(v, 0) || math.Abs(v) > math.MaxInt64/100 {
+		return 0, fmt.Errorf("%q is not an amount", s)
+	}
+	return Money(math.Round(v * 100)), nil
+}
+
+func (m Money) String() string {
+	sign, cents := "", int64(m)
+	if cents < 0 {
+		sign, cents = "-", -cents
+	}
+	return fmt.Sprintf("%s%d.%02d", sign, cents/100, cents%100)
+}
+
+// Float returns m in currency units, for metrics.
+func (m Money) Float() float64 {
+	return float64(m) / 100
+}
+
+func (m Money) MarshalJSON() ([]byte, error) {
+	return []byte(m.String()), nil
+}
+
+func (m *Money) UnmarshalJSON(data []byte) error {
+	if bytes.Equal(data, []byte("null")) {
+		return nil
+	}
+	v, err := parseMoney(string(data))
+	if err != nil {
+		return fmt.Errorf("invalid amount %s", data)
+	}
+	*m = v
+	return nil
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// load replaces the store's contents with those of its file. It reports
+// false when no file is configured or it does not exist yet so the caller
+// can seed instead.
+func (s *memoryStore) load() (bool, error) {
+	if s.file == "" {
+		return false, nil
+	}
+	data, err := os.ReadFile(s.file)
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	// Older files are a bare array of orders; their next ID can only be
+	// derived from the highest ID still present.
+	var snap ordersSnapshot
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '[' {
+		err = json.Unmarshal(data, &snap.Orders)
+	} else {
+		err = json.Unmarshal(data, &snap)
+	}
+	if err != nil {
+		return false, fmt.Errorf("parse %s: %w", s.file, err)
+	}
+	for i := range snap.Orders {
+		if snap.Orders[i].Version == 0 {
+			snap.Orders[i].Version = 1
+		}
+		snap.Orders[i].History = snap.History[snap.Orders[i].ID]
+	}
+	s.seed(snap.Orders)
+	s.raiseNextID(snap.NextID)
+	log.Printf("Loaded %d orders from %s", len(snap.Orders), s.file)
+	return true, nil
+}
+
+// ordersSnapshot is the on-disk format. NextID is kept alongside the orders
+// so IDs of hard-deleted orders are not handed out again after a restart.
+// History is keyed by order ID since Order leaves it out of its JSON.
+type ordersSnapshot struct {
+	NextID  int                  `json:"next_id"`
+	Orders  []Order              `json:"orders"`
+	History map[int][]OrderEvent `json:"history,omitempty"`
+}
+
+// Writes are retried this many times in total, doubling the delay from
+// persistBackoff between attempts. The lock is held throughout, so keep the
+// total delay short.
+const (
+	persistAttempts = 3
+	persistBackoff  = 50 * time.Millisecond
+)
+
+// persist writes the full store to its file. Callers must hold s.mu so a
+// partially-applied mutation is never serialized. A write that keeps failing
+// leaves the in-memory change in place, which stays authoritative, and marks
+// the store unhealthy until a later write succeeds.
+func (s *memoryStore) persist() {
+	if s.file == "" {
+		return
+	}
+	s.persistErr = s.writeFileWithRetry()
+	if s.persistErr != nil {
+		logEvent(nil, "error", "Failed to persist orders; in-memory state is ahead of disk",
+			"file", s.file, "attempts", persistAttempts, "error", s.persistErr.Error())
+	}
+}
+
+func (s *memoryStore) writeFileWithRetry() error {
+	var err error
+	delay := persistBackoff
+	for attempt := 1; attempt <= persistAttempts; attempt++ {
+		if err = s.writeFile(); err == nil {
+			return nil
+		}
+		if attempt < persistAttempts {
+			logEvent(nil, "warn", "Write failed, retrying", "file", s.file, "attempt", attempt, "attempts", persistAttempts, "error", err.Error())
+			time.Sleep(delay)
+			delay *= 2
+		}
+	}
+	return err
+}
+
+// flush synchronously writes the store to its file, returning how many
+// orders were written, for a final save at shutdown.
+func (s *memoryStore) flush() (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.file == "" {
+		return 0, nil
+	}
+	s.persistErr = s.writeFileWithRetry()
+	return len(s.orders), s.persistErr
+}
+
+// Health reports why the store can't be trusted, if anything.
+func (s *memoryStore) Health() error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.orders == nil {
+		return errors.New("order store is not initialized")
+	}
+	if s.file == "" {
+		return nil
+	}
+	if s.persistErr != nil {
+		return fmt.Errorf("last write to %s failed: %w", s.file, s.persistErr)
+	}
+	if _, err := os.Stat(filepath.Dir(s.file)); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (s *memoryStore) writeFile() error {
+	snap := ordersSnapshot{NextID: int(s.nextID.Load()), Orders: make([]Order, 0, len(s.orders))}
+	for _, o := range s.orders {
+		snap.Orders = append(snap.Orders, *o)
+		if len(o.History) > 0 {
+			if snap.History == nil {
+				snap.History = make(map[int][]OrderEvent)
+			}
+			snap.History[o.ID] = o.History
+		}
+	}
+	sort.Slice(snap.Orders, func(i, j int) bool { return snap.Orders[i].ID < snap.Orders[j].ID })
+
+	data, err := json.MarshalIndent(snap, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.file), filepath.Base(s.file)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.file)
+}
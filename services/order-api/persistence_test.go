@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadContinuesAfterHighestID(t *testing.T) {
+	orders := `{"id":1,"customer_id":1,"product_id":1,"quantity":1,"status":"pending"},` +
+		`{"id":5,"customer_id":1,"product_id":1,"quantity":1,"status":"pending"},` +
+		`{"id":9,"customer_id":1,"product_id":1,"quantity":1,"status":"pending"}`
+	tests := []struct {
+		name   string
+		file   string
+		wantID int
+	}{
+		{"bare array", `[` + orders + `]`, 10},
+		{"snapshot", `{"next_id":10,"orders":[` + orders + `]}`, 10},
+		// 10 and 11 were hard-deleted before the snapshot was written.
+		{"snapshot past deleted IDs", `{"next_id":12,"orders":[` + orders + `]}`, 12},
+		{"snapshot with stale next_id", `{"next_id":3,"orders":[` + orders + `]}`, 10},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := filepath.Join(t.TempDir(), "orders.json")
+			if err := os.WriteFile(file, []byte(tt.file), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			s := newMemoryStore(file)
+			loaded, err := s.load()
+			if err != nil || !loaded {
+				t.Fatalf("load() = %t, %v", loaded, err)
+			}
+			created, err := s.Create(context.Background(), Order{CustomerID: 1, ProductID: 1, Quantity: 1, Status: statusPending})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if created.ID != tt.wantID {
+				t.Fatalf("next create got ID %d, want %d", created.ID, tt.wantID)
+			}
+		})
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// prettyWriter buffers a response so a JSON body can be re-indented once
+// the handler is done.
+type prettyWriter struct {
+	http.ResponseWriter
+	status int
+	buf    bytes.Buffer
+}
+
+func (p *prettyWriter) WriteHeader(code int) {
+	if p.status == 0 {
+		p.status = code
+	}
+}
+
+func (p *prettyWriter) Write(b []byte) (int, error) {
+	return p.buf.Write(b)
+}
+
+func (p *prettyWriter) finish() {
+	body := p.buf.Bytes()
+	if strings.HasPrefix(p.Header().Get("Content-Type"), "application/json") && len(body) > 0 {
+		var out bytes.Buffer
+		if err := json.Indent(&out, body, "", "  "); err == nil {
+			body = out.Bytes()
+			p.Header().Set("Content-Length", strconv.Itoa(len(body)))
+		}
+	}
+	if p.status != 0 {
+		p.ResponseWriter.WriteHeader(p.status)
+	}
+	p.ResponseWriter.Write(body)
+}
+
+// prettyJSON indents JSON responses when the request has ?pretty=true, or
+// for every request when always is set (PRETTY_JSON).
+func prettyJSON(always bool, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !always && !queryBool(r, "pretty") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		// HEAD is served as GET so Content-Length is computed from the
+		// indented body, as in stripEnvelope.
+		if r.Method == http.MethodHead {
+			r = r.Clone(r.Context())
+			r.Method = http.MethodGet
+		}
+		pw := &prettyWriter{ResponseWriter: w}
+		next.ServeHTTP(pw, r)
+		pw.finish()
+	})
+}
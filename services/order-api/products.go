@@ -0,0 +1,101 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+)
+
+type Product struct {
+	ID    int    `json:"id"`
+	Name  string `json:"name"`
+	Price Money  `json:"price"`
+}
+
+var products = make(map[int]*Product)
+
+func initProducts() {
+	for _, p := range []*Product{
+		{ID: 1, Name: "Laptop", Price: 99999},
+		{ID: 2, Name: "Mouse", Price: 2999},
+		{ID: 3, Name: "Keyboard", Price: 7999},
+		{ID: 4, Name: "Monitor", Price: 29999},
+		{ID: 5, Name: "Webcam", Price: 8999},
+	} {
+		products[p.ID] = p
+	}
+	log.Printf("Initialized %d products", len(products))
+}
+
+// priceOrder sets unit prices and Total from the catalog, overriding anything
+// the client sent. Orders with Items are priced per item and the legacy
+// single-product fields are cleared.
+func priceOrder(o *Order) error {
+	if len(o.Items) == 0 {
+		p, ok := products[o.ProductID]
+		if !ok {
+			return fmt.Errorf("Unknown product %d", o.ProductID)
+		}
+		o.UnitPrice = p.Price
+		o.Total = p.Price * Money(o.Quantity)
+		return nil
+	}
+
+	var total Money
+	for i := range o.Items {
+		item := &o.Items[i]
+		p, ok := products[item.ProductID]
+		if !ok {
+			return fmt.Errorf("Unknown product %d in item %d", item.ProductID, i)
+		}
+		item.UnitPrice = p.Price
+		total += p.Price * Money(item.Quantity)
+	}
+	o.ProductID, o.Quantity, o.UnitPrice = 0, 0, 0
+	o.Total = total
+	return nil
+}
+
+func roundCents(v float64) float64 {
+	return math.Round(v*100) / 100
+}
+
+func productsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != "GET" {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	list := make([]*Product, 0, len(products))
+	for _, p := range products {
+		list = append(list, p)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+
+	json.NewEncoder(w).Encode(Response{Success: true, Count: counted(len(list)), Data: emptyIfNil(list)})
+}
+
+func productHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != "GET" {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	id, err := strconv.Atoi(r.URL.Path[len("/api/products/"):])
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid product ID")
+		return
+	}
+
+	p, ok := products[id]
+	if !ok {
+		writeErrorCode(w, http.StatusNotFound, "product_not_found", "Product not found")
+		return
+	}
+	json.NewEncoder(w).Encode(Response{Success: true, Data: p})
+}
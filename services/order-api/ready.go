@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// readinessTimeout bounds each dependency check so a hung dependency can't
+// stall the probe past the orchestrator's own timeout.
+const readinessTimeout = time.Second
+
+// readinessCheck is one dependency /ready reports on. A failing critical
+// check makes the service unready; other checks are reported only.
+type readinessCheck struct {
+	name     string
+	critical bool
+	check    func(ctx context.Context) error
+}
+
+type checkResult struct {
+	Status   string `json:"status"`
+	Critical bool   `json:"critical"`
+	Error    string `json:"error,omitempty"`
+}
+
+// readinessChecks returns the checks for what is configured: the store
+// always, and the customer service and webhook receiver when set.
+func readinessChecks() []readinessCheck {
+	checks := []readinessCheck{{
+		name:     "store",
+		critical: true,
+		check:    func(context.Context) error { return store.Health() },
+	}}
+	if customerAPIURL != "" {
+		checks = append(checks, readinessCheck{
+			name:  "customer_service",
+			check: func(ctx context.Context) error { return probeURL(ctx, customerClient, customerAPIURL) },
+		})
+	}
+	if webhookURL != "" {
+		checks = append(checks, readinessCheck{
+			name:  "webhook",
+			check: func(ctx context.Context) error { return probeURL(ctx, webhookClient, webhookURL) },
+		})
+	}
+	return checks
+}
+
+// runReadinessChecks runs checks concurrently and reports whether every
+// critical one passed.
+func runReadinessChecks(ctx context.Context, checks []readinessCheck) (map[string]checkResult, bool) {
+	results := make(map[string]checkResult, len(checks))
+	ok := true
+	var (
+		mu sync.Mutex
+		wg sync.WaitGroup
+	)
+	for _, c := range checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(ctx, readinessTimeout)
+			defer cancel()
+			res := checkResult{Status: "ok", Critical: c.critical}
+			if err := c.check(ctx); err != nil {
+				res.Status, res.Error = "failing", err.Error()
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			results[c.name] = res
+			if res.Error != "" && c.critical {
+				ok = false
+			}
+		}()
+	}
+	wg.Wait()
+	return results, ok
+}
+
+// probeURL checks that url answers at all; any HTTP response counts, since
+// receivers are free to reject a bare HEAD.
+func probeURL(ctx context.Context, client *http.Client, url string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("unreachable: %w", err)
+	}
+	resp.Body.Close()
+	return nil
+}
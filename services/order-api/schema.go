@@ -0,0 +1,191 @@
+package main
+
+import (
+	"bytes"
+	"embed"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// schemaFiles holds the JSON Schemas request bodies are checked against,
+// so they ship with the binary and can be handed to clients as they are.
+//
+//go:embed schemas
+var schemaFiles embed.FS
+
+var (
+	orderSchema      = mustLoadSchema("schemas/order.json")
+	orderPatchSchema = mustLoadSchema("schemas/order-patch.json")
+)
+
+// jsonSchema is the subset of JSON Schema the embedded schemas use. Loading
+// a schema with any other keyword fails, rather than silently not enforcing
+// it. Business rules that depend on configuration, like MAX_QUANTITY, stay in
+// validateOrder.
+type jsonSchema struct {
+	Schema               string                 `json:"$schema"`
+	Title                string                 `json:"title"`
+	Description          string                 `json:"description"`
+	Type                 schemaTypes            `json:"type"`
+	Properties           map[string]*jsonSchema `json:"properties"`
+	Required             []string               `json:"required"`
+	AdditionalProperties *bool                  `json:"additionalProperties"`
+	Items                *jsonSchema            `json:"items"`
+	AnyOf                []*jsonSchema          `json:"anyOf"`
+	Minimum              *float64               `json:"minimum"`
+	MaxLength            *int                   `json:"maxLength"`
+	MinLength            *int                   `json:"minLength"`
+	Format               string                 `json:"format"`
+}
+
+// schemaTypes is the "type" keyword, which may be one type name or a list.
+type schemaTypes []string
+
+func (t *schemaTypes) UnmarshalJSON(data []byte) error {
+	var one string
+	if err := json.Unmarshal(data, &one); err == nil {
+		*t = schemaTypes{one}
+		return nil
+	}
+	return json.Unmarshal(data, (*[]string)(t))
+}
+
+func mustLoadSchema(name string) *jsonSchema {
+	data, err := schemaFiles.ReadFile(name)
+	if err != nil {
+		panic(err)
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	var s jsonSchema
+	if err := dec.Decode(&s); err != nil {
+		panic(fmt.Sprintf("schema %s: %v", name, err))
+	}
+	return &s
+}
+
+// schemaViolation is one way a body fails its schema. Field is a JSON
+// Pointer to the offending value, or to where a missing one belongs.
+type schemaViolation struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// validate checks v, a document decoded with UseNumber, against s and
+// returns every violation found. A value of the wrong type is reported once,
+// without checking what is inside it.
+func (s *jsonSchema) validate(field string, v interface{}) []schemaViolation {
+	if len(s.Type) > 0 && !slices.ContainsFunc(s.Type, func(t string) bool { return hasSchemaType(v, t) }) {
+		return []schemaViolation{{field, "must be " + strings.Join(s.Type, " or ")}}
+	}
+
+	var out []schemaViolation
+	add := func(format string, args ...interface{}) {
+		out = append(out, schemaViolation{field, fmt.Sprintf(format, args...)})
+	}
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for _, name := range s.Required {
+			if _, ok := v[name]; !ok {
+				out = append(out, schemaViolation{field + "/" + name, "is required"})
+			}
+		}
+		names := make([]string, 0, len(v))
+		for name := range v {
+			names = append(names, name)
+		}
+		slices.Sort(names)
+		for _, name := range names {
+			if prop, ok := s.Properties[name]; ok {
+				out = append(out, prop.validate(field+"/"+name, v[name])...)
+			} else if s.AdditionalProperties != nil && !*s.AdditionalProperties {
+				out = append(out, schemaViolation{field + "/" + name, "is not an allowed field"})
+			}
+		}
+	case []interface{}:
+		if s.Items != nil {
+			for i, item := range v {
+				out = append(out, s.Items.validate(field+"/"+strconv.Itoa(i), item)...)
+			}
+		}
+	case json.Number:
+		if n, _ := v.Float64(); s.Minimum != nil && n < *s.Minimum {
+			add("must be at least %v", *s.Minimum)
+		}
+	case string:
+		n := utf8.RuneCountInString(v)
+		if s.MinLength != nil && n < *s.MinLength {
+			add("must be at least %d characters", *s.MinLength)
+		}
+		if s.MaxLength != nil && n > *s.MaxLength {
+			add("must be at most %d characters", *s.MaxLength)
+		}
+		if s.Format == "date-time" {
+			if _, err := time.Parse(time.RFC3339, v); err != nil {
+				add("must be an RFC 3339 date-time")
+			}
+		}
+	}
+
+	if len(s.AnyOf) > 0 {
+		var alternatives []string
+		for _, alt := range s.AnyOf {
+			vs := alt.validate(field, v)
+			if len(vs) == 0 {
+				alternatives = nil
+				break
+			}
+			msgs := make([]string, len(vs))
+			for i, v := range vs {
+				msgs[i] = v.Field + " " + v.Message
+			}
+			alternatives = append(alternatives, strings.Join(msgs, " and "))
+		}
+		if len(alternatives) > 0 {
+			add("must satisfy one of: %s", strings.Join(alternatives, "; or "))
+		}
+	}
+	return out
+}
+
+// hasSchemaType reports whether v is of the JSON Schema type t.
+func hasSchemaType(v interface{}, t string) bool {
+	switch v := v.(type) {
+	case nil:
+		return t == "null"
+	case bool:
+		return t == "boolean"
+	case string:
+		return t == "string"
+	case []interface{}:
+		return t == "array"
+	case map[string]interface{}:
+		return t == "object"
+	case json.Number:
+		if t == "number" {
+			return true
+		}
+		_, err := v.Int64()
+		return t == "integer" && err == nil
+	}
+	return false
+}
+
+// writeViolations rejects a body that failed its schema, listing every
+// violation so a client can fix them all in one go.
+func writeViolations(w http.ResponseWriter, violations []schemaViolation) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusUnprocessableEntity)
+	json.NewEncoder(w).Encode(Response{
+		Success:    false,
+		Error:      "Request body does not match its schema",
+		Code:       "schema_violation",
+		Violations: violations,
+	})
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"errors"
+	"sync"
+)
+
+// flightGroup collapses concurrent calls with the same key into one, in the
+// manner of golang.org/x/sync/singleflight, which this module doesn't depend
+// on. Results are only shared with callers that arrive while the call is in
+// flight; nothing is cached after it returns.
+type flightGroup[T any] struct {
+	mu    sync.Mutex
+	calls map[string]*flightCall[T]
+}
+
+type flightCall[T any] struct {
+	done chan struct{}
+	val  T
+	err  error
+
+	// panicked is what fn panicked with, if it did, so every caller panics
+	// with it rather than taking the zero T as a result.
+	panicked interface{}
+}
+
+// errFlightExited is what callers sharing a run get when fn ends its
+// goroutine with runtime.Goexit instead of returning.
+var errFlightExited = errors.New("shared call exited without returning")
+
+// do runs fn once for all concurrent callers with key and hands each the
+// same result, reporting whether it came from another caller's run.
+func (g *flightGroup[T]) do(key string, fn func() (T, error)) (T, bool, error) {
+	g.mu.Lock()
+	if c, ok := g.calls[key]; ok {
+		g.mu.Unlock()
+		<-c.done
+		if c.panicked != nil {
+			panic(c.panicked)
+		}
+		return c.val, true, c.err
+	}
+	if g.calls == nil {
+		g.calls = make(map[string]*flightCall[T])
+	}
+	c := &flightCall[T]{done: make(chan struct{})}
+	g.calls[key] = c
+	g.mu.Unlock()
+
+	returned := false
+	defer func() {
+		if !returned {
+			if r := recover(); r != nil {
+				c.panicked = r
+			} else {
+				c.err = errFlightExited
+			}
+		}
+		g.mu.Lock()
+		delete(g.calls, key)
+		g.mu.Unlock()
+		close(c.done)
+		if c.panicked != nil {
+			panic(c.panicked)
+		}
+	}()
+	c.val, c.err = fn()
+	returned = true
+	return c.val, false, c.err
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"errors"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// startLeader runs g.do(key, fn) in the background with fn blocked until
+// the returned release is called, and waits until the call is in flight.
+func startLeader(g *flightGroup[int], key string, fn func() (int, error)) (release func(), done <-chan struct{}) {
+	started, unblock, finished := make(chan struct{}), make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(finished)
+		defer func() { recover() }()
+		g.do(key, func() (int, error) {
+			close(started)
+			<-unblock
+			return fn()
+		})
+	}()
+	<-started
+	return func() { close(unblock) }, finished
+}
+
+// flightResult is what one caller of do got, or panicked with.
+type flightResult struct {
+	val      int
+	shared   bool
+	err      error
+	panicked interface{}
+}
+
+// joinFlight calls g.do(key) from n goroutines that expect to share the
+// call in flight; wait returns what each of them got.
+func joinFlight(g *flightGroup[int], key string, n int) (wait func() []flightResult) {
+	results := make([]flightResult, n)
+	var wg sync.WaitGroup
+	for i := range results {
+		wg.Add(1)
+		go func(res *flightResult) {
+			defer wg.Done()
+			defer func() { res.panicked = recover() }()
+			res.val, res.shared, res.err = g.do(key, func() (int, error) {
+				return -1, errors.New("ran instead of sharing the call in flight")
+			})
+		}(&results[i])
+	}
+	// Let the waiters reach the call before it is released.
+	time.Sleep(20 * time.Millisecond)
+	return func() []flightResult {
+		wg.Wait()
+		return results
+	}
+}
+
+func TestFlightGroupSharesOneCall(t *testing.T) {
+	var g flightGroup[int]
+	release, done := startLeader(&g, "k", func() (int, error) { return 42, nil })
+	wait := joinFlight(&g, "k", 5)
+	release()
+	<-done
+
+	for i, res := range wait() {
+		if res.val != 42 || !res.shared || res.err != nil {
+			t.Errorf("caller %d got %+v", i, res)
+		}
+	}
+	// Nothing is kept once the call is done.
+	if v, shared, _ := g.do("k", func() (int, error) { return 7, nil }); v != 7 || shared {
+		t.Fatalf("later call = %d, shared %t", v, shared)
+	}
+}
+
+func TestFlightGroupKeysAreSeparate(t *testing.T) {
+	var g flightGroup[int]
+	release, done := startLeader(&g, "a", func() (int, error) { return 1, nil })
+	defer func() { release(); <-done }()
+
+	var calls atomic.Int32
+	v, shared, err := g.do("b", func() (int, error) {
+		calls.Add(1)
+		return 2, nil
+	})
+	if v != 2 || shared || err != nil || calls.Load() != 1 {
+		t.Fatalf("other key = %d, shared %t, %v after %d calls", v, shared, err, calls.Load())
+	}
+}
+
+func TestFlightGroupPropagatesPanic(t *testing.T) {
+	var g flightGroup[int]
+	release, done := startLeader(&g, "k", func() (int, error) { panic("boom") })
+	wait := joinFlight(&g, "k", 3)
+	release()
+	<-done
+
+	for i, res := range wait() {
+		if res.panicked != "boom" {
+			t.Errorf("caller %d got %+v, want a panic with boom", i, res)
+		}
+	}
+}
+
+func TestFlightGroupGoexit(t *testing.T) {
+	var g flightGroup[int]
+	release, done := startLeader(&g, "k", func() (int, error) {
+		runtime.Goexit()
+		return 0, nil
+	})
+	wait := joinFlight(&g, "k", 3)
+	release()
+	<-done
+
+	for i, res := range wait() {
+		if !errors.Is(res.err, errFlightExited) || !res.shared {
+			t.Errorf("caller %d got %+v, want errFlightExited", i, res)
+		}
+	}
+	// The key is free again for the next call.
+	if v, _, err := g.do("k", func() (int, error) { return 3, nil }); v != 3 || err != nil {
+		t.Fatalf("call after Goexit = %d, %v", v, err)
+	}
+}
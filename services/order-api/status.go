@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	statusPending    = "pending"
+	statusProcessing = "processing"
+	statusShipped    = "shipped"
+	statusCompleted  = "completed"
+	statusCancelled  = "cancelled"
+
+	// statusExpired is set only by the pending-order sweeper, so it is not
+	// a transition clients can ask for.
+	statusExpired = "expired"
+)
+
+var validStatuses = []string{statusPending, statusProcessing, statusShipped, statusCompleted, statusCancelled, statusExpired}
+
+// statusTransitions lists the statuses each status may move to. Statuses
+// without an entry are terminal.
+var statusTransitions = map[string][]string{
+	statusPending:    {statusProcessing, statusCancelled},
+	statusProcessing: {statusShipped, statusCancelled},
+	statusShipped:    {statusCompleted, statusCancelled},
+}
+
+// initialStatus is what new orders start in, set from INITIAL_STATUS.
+var initialStatus = statusPending
+
+// setInitialStatus validates status as a starting point: it must be known
+// and must still have somewhere to go in statusTransitions.
+func setInitialStatus(status string) error {
+	status = normalizeStatus(status)
+	if !isValidStatus(status) {
+		return fmt.Errorf("unknown status %q; valid: %v", status, validStatuses)
+	}
+	if isTerminalStatus(status) {
+		return fmt.Errorf("%s is terminal, so orders could never leave it", status)
+	}
+	initialStatus = status
+	return nil
+}
+
+// normalizeStatus maps client spellings like " Pending " onto the canonical
+// lowercase status; the result still has to pass isValidStatus.
+func normalizeStatus(status string) string {
+	return strings.ToLower(strings.TrimSpace(status))
+}
+
+// stampStatusTime records at as when o entered its current status, unless
+// it has been there before.
+func stampStatusTime(o *Order, at time.Time) {
+	var field **time.Time
+	switch o.Status {
+	case statusProcessing:
+		field = &o.ProcessingAt
+	case statusShipped:
+		field = &o.ShippedAt
+	case statusCompleted:
+		field = &o.CompletedAt
+	case statusCancelled:
+		field = &o.CancelledAt
+	default:
+		return
+	}
+	if *field == nil {
+		*field = &at
+	}
+}
+
+// clearStatusTimes drops any status timestamps a client sent with a new
+// order, so only the server sets them.
+func clearStatusTimes(o *Order) {
+	o.CancelledAt, o.ProcessingAt, o.ShippedAt, o.CompletedAt = nil, nil, nil, nil
+}
+
+func isValidStatus(status string) bool {
+	for _, s := range validStatuses {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
+
+func isTerminalStatus(status string) bool {
+	return len(statusTransitions[status]) == 0
+}
+
+func checkTransition(from, to string) error {
+	if from == to {
+		return nil
+	}
+	for _, next := range statusTransitions[from] {
+		if next == to {
+			return nil
+		}
+	}
+	if isTerminalStatus(from) {
+		return fmt.Errorf("Cannot change status of %s order to %s", from, to)
+	}
+	return fmt.Errorf("Cannot change status from %s to %s; allowed: %v", from, to, statusTransitions[from])
+}
@@ -0,0 +1,503 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	errOrderNotFound = errors.New("Order not found")
+	errStoreFull     = errors.New("Order store is full")
+	errCustomerLimit = errors.New("Customer has reached the active order limit")
+)
+
+// OrderStore is everything the handlers need from order storage. Update and
+// the batch methods apply fn to a copy of the stored order and only commit it
+// when fn returns nil, so validation against the current state is atomic.
+//
+// Orders go in and come out by value, deep-copied under the lock, so callers
+// never share memory with the stored order and can read or modify what they
+// get back while other requests update it.
+//
+// Operations give up with ctx.Err() once ctx is done, checking it between
+// items in scans and batches. A batch that gives up writes nothing.
+type OrderStore interface {
+	Get(ctx context.Context, id int) (Order, error)
+	GetByNumber(ctx context.Context, number string) (Order, error)
+	List(ctx context.Context) ([]Order, error)
+	Find(ctx context.Context, match func(o *Order) bool) ([]Order, error)
+	Create(ctx context.Context, o Order) (Order, error)
+	Update(ctx context.Context, id int, fn func(o *Order) error) (Order, error)
+	Upsert(ctx context.Context, id int, fn func(o *Order, exists bool) error) (Order, bool, error)
+	Delete(ctx context.Context, id int) (Order, error)
+	UpdateMany(ctx context.Context, ids []int, fn func(o *Order) error) ([]Order, []error, error)
+	DeleteMany(ctx context.Context, ids []int) ([]Order, []error, error)
+	LastModified() time.Time
+	Health() error
+}
+
+var store OrderStore
+
+type memoryStore struct {
+	mu           sync.RWMutex
+	orders       map[int]*Order
+	lastModified time.Time
+	file         string
+	persistErr   error
+
+	// nextID is the next ID to hand out. It is allocated atomically so
+	// creates don't contend for mu just to number an order; an ID taken by a
+	// create that then fails is not reused.
+	nextID atomic.Int64
+
+	// randomIDs hands out random IDs instead of nextID, so IDs don't reveal
+	// how many orders there are or let clients walk them. The costs: IDs no
+	// longer sort by creation, a hard-deleted order's ID may come up again,
+	// and each create needs mu before it can pick one. Upserts and seeding
+	// still use the IDs they are given.
+	randomIDs bool
+
+	// maxOrders caps the number of stored orders; zero means unbounded.
+	// When evict is set, a create at the cap drops the oldest order in a
+	// terminal status instead of failing with errStoreFull.
+	maxOrders int
+	evict     bool
+
+	// maxActivePerCustomer caps a customer's orders that are neither deleted
+	// nor in a terminal status; zero means unlimited.
+	maxActivePerCustomer int
+}
+
+// newMemoryStore returns an empty store that persists to file after every
+// mutation, or keeps orders in memory only if file is empty.
+func newMemoryStore(file string) *memoryStore {
+	s := &memoryStore{
+		orders:       make(map[int]*Order),
+		lastModified: now(),
+		file:         file,
+	}
+	s.nextID.Store(1)
+	return s
+}
+
+// seed replaces the store's contents without persisting them.
+func (s *memoryStore) seed(list []Order) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.orders = make(map[int]*Order, len(list))
+	s.nextID.Store(1)
+	for _, o := range list {
+		o := cloneOrder(o)
+		if o.OrderNumber == "" {
+			o.OrderNumber = s.newOrderNumber()
+		}
+		s.orders[o.ID] = &o
+		s.raiseNextID(o.ID + 1)
+	}
+	s.lastModified = now()
+}
+
+func (s *memoryStore) Get(ctx context.Context, id int) (Order, error) {
+	if err := ctx.Err(); err != nil {
+		return Order{}, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	o, ok := s.orders[id]
+	if !ok {
+		return Order{}, errOrderNotFound
+	}
+	return cloneOrder(*o), nil
+}
+
+func (s *memoryStore) GetByNumber(ctx context.Context, number string) (Order, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, o := range s.orders {
+		if err := ctx.Err(); err != nil {
+			return Order{}, err
+		}
+		if o.OrderNumber == number {
+			return cloneOrder(*o), nil
+		}
+	}
+	return Order{}, errOrderNotFound
+}
+
+func (s *memoryStore) List(ctx context.Context) ([]Order, error) {
+	return s.Find(ctx, func(*Order) bool { return true })
+}
+
+// Find returns copies of the orders match accepts, scanning under a single
+// read lock. match must not modify the order it is given.
+func (s *memoryStore) Find(ctx context.Context, match func(o *Order) bool) ([]Order, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	list := make([]Order, 0)
+	for _, o := range s.orders {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if match(o) {
+			list = append(list, cloneOrder(*o))
+		}
+	}
+	return list, nil
+}
+
+func (s *memoryStore) Create(ctx context.Context, o Order) (Order, error) {
+	if err := ctx.Err(); err != nil {
+		return Order{}, err
+	}
+	o = cloneOrder(o)
+	if !s.randomIDs {
+		o.ID = int(s.nextID.Add(1) - 1)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.checkCustomerLimit(o.CustomerID); err != nil {
+		return Order{}, err
+	}
+	if err := s.makeRoom(); err != nil {
+		return Order{}, err
+	}
+	if s.randomIDs {
+		o.ID = s.newRandomID()
+	}
+	// An upsert may have claimed the ID between allocation and locking.
+	for s.orders[o.ID] != nil {
+		o.ID = int(s.nextID.Add(1) - 1)
+	}
+
+	o.OrderNumber = s.newOrderNumber()
+	s.orders[o.ID] = &o
+	s.changed()
+	return cloneOrder(o), nil
+}
+
+func (s *memoryStore) Update(ctx context.Context, id int, fn func(o *Order) error) (Order, error) {
+	if err := ctx.Err(); err != nil {
+		return Order{}, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	o, err := s.update(id, fn)
+	if err != nil {
+		return Order{}, err
+	}
+	s.changed()
+	return o, nil
+}
+
+// Upsert updates the order like Update when it exists. Otherwise fn is
+// given a fresh order and, on success, it is created under id; the bool
+// result reports which happened.
+func (s *memoryStore) Upsert(ctx context.Context, id int, fn func(o *Order, exists bool) error) (Order, bool, error) {
+	if err := ctx.Err(); err != nil {
+		return Order{}, false, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.orders[id]; ok {
+		o, err := s.update(id, func(o *Order) error { return fn(o, true) })
+		if err != nil {
+			return Order{}, false, err
+		}
+		s.changed()
+		return o, false, nil
+	}
+
+	var o Order
+	if err := fn(&o, false); err != nil {
+		return Order{}, false, err
+	}
+	if err := s.checkCustomerLimit(o.CustomerID); err != nil {
+		return Order{}, false, err
+	}
+	if err := s.makeRoom(); err != nil {
+		return Order{}, false, err
+	}
+	o.ID = id
+	o.OrderNumber = s.newOrderNumber()
+	s.orders[id] = &o
+	s.raiseNextID(id + 1)
+	s.changed()
+	return cloneOrder(o), true, nil
+}
+
+func (s *memoryStore) Delete(ctx context.Context, id int) (Order, error) {
+	if err := ctx.Err(); err != nil {
+		return Order{}, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	o, ok := s.orders[id]
+	if !ok {
+		return Order{}, errOrderNotFound
+	}
+	delete(s.orders, id)
+	s.changed()
+	return cloneOrder(*o), nil
+}
+
+// UpdateMany applies fn to each order in turn; a repeated ID sees the result
+// of its earlier update. Nothing is stored until every ID has been handled.
+func (s *memoryStore) UpdateMany(ctx context.Context, ids []int, fn func(o *Order) error) ([]Order, []error, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	updated := make([]Order, len(ids))
+	errs := make([]error, len(ids))
+	staged := make(map[int]*Order)
+	for i, id := range ids {
+		if err := ctx.Err(); err != nil {
+			return nil, nil, err
+		}
+		o, ok := staged[id]
+		if !ok {
+			o, ok = s.orders[id]
+		}
+		if !ok {
+			errs[i] = errOrderNotFound
+			continue
+		}
+		next := cloneOrder(*o)
+		if errs[i] = fn(&next); errs[i] != nil {
+			continue
+		}
+		next.ID = id
+		next.OrderNumber = o.OrderNumber
+		staged[id] = &next
+		updated[i] = cloneOrder(next)
+	}
+
+	for id, o := range staged {
+		s.orders[id] = o
+	}
+	if len(staged) > 0 {
+		s.changed()
+	}
+	return updated, errs, nil
+}
+
+// DeleteMany removes each listed order; a repeated ID is not found the
+// second time. Nothing is removed until every ID has been handled.
+func (s *memoryStore) DeleteMany(ctx context.Context, ids []int) ([]Order, []error, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	deleted := make([]Order, len(ids))
+	errs := make([]error, len(ids))
+	staged := make(map[int]bool)
+	for i, id := range ids {
+		if err := ctx.Err(); err != nil {
+			return nil, nil, err
+		}
+		o, ok := s.orders[id]
+		if !ok || staged[id] {
+			errs[i] = errOrderNotFound
+			continue
+		}
+		staged[id] = true
+		deleted[i] = cloneOrder(*o)
+	}
+
+	for id := range staged {
+		delete(s.orders, id)
+	}
+	if len(staged) > 0 {
+		s.changed()
+	}
+	return deleted, errs, nil
+}
+
+func (s *memoryStore) LastModified() time.Time {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.lastModified
+}
+
+// update applies fn to a copy of the order and stores the result. Callers
+// must hold s.mu for writing.
+func (s *memoryStore) update(id int, fn func(o *Order) error) (Order, error) {
+	o, ok := s.orders[id]
+	if !ok {
+		return Order{}, errOrderNotFound
+	}
+	next := cloneOrder(*o)
+	if err := fn(&next); err != nil {
+		return Order{}, err
+	}
+	next.ID = id
+	next.OrderNumber = o.OrderNumber
+	s.orders[id] = &next
+	return cloneOrder(next), nil
+}
+
+// raiseNextID makes sure no ID below next is handed out from now on.
+func (s *memoryStore) raiseNextID(next int) {
+	for {
+		cur := s.nextID.Load()
+		if cur >= int64(next) || s.nextID.CompareAndSwap(cur, int64(next)) {
+			return
+		}
+	}
+}
+
+// checkCustomerLimit reports errCustomerLimit if the customer can't have
+// another active order. Callers must hold s.mu.
+func (s *memoryStore) checkCustomerLimit(customerID int) error {
+	if s.maxActivePerCustomer <= 0 {
+		return nil
+	}
+	active := 0
+	for _, o := range s.orders {
+		if o.CustomerID == customerID && o.DeletedAt == nil && !isTerminalStatus(o.Status) {
+			active++
+		}
+	}
+	if active >= s.maxActivePerCustomer {
+		return fmt.Errorf("%w: customer %d has %d active orders (max %d)", errCustomerLimit, customerID, active, s.maxActivePerCustomer)
+	}
+	return nil
+}
+
+// makeRoom ensures one more order fits under maxOrders, evicting if that is
+// allowed. Callers must hold s.mu for writing.
+func (s *memoryStore) makeRoom() error {
+	if s.maxOrders > 0 && len(s.orders) >= s.maxOrders {
+		if !s.evict || !s.evictOldest() {
+			return errStoreFull
+		}
+	}
+	return nil
+}
+
+// evictOldest drops the oldest soft-deleted order, or failing that the
+// oldest order in a terminal status, reporting false if there is neither.
+// Soft-deleted orders still take up room, so they go first whatever their
+// status. Callers must hold s.mu for writing.
+func (s *memoryStore) evictOldest() bool {
+	var oldest *Order
+	for _, o := range s.orders {
+		deleted := o.DeletedAt != nil
+		if !deleted && !isTerminalStatus(o.Status) {
+			continue
+		}
+		switch {
+		case oldest == nil:
+			oldest = o
+		case deleted != (oldest.DeletedAt != nil):
+			if deleted {
+				oldest = o
+			}
+		case o.CreatedAt.Before(oldest.CreatedAt):
+			oldest = o
+		}
+	}
+	if oldest == nil {
+		return false
+	}
+	delete(s.orders, oldest.ID)
+	logEvent(nil, "info", "Evicted order", "order_id", oldest.ID, "status", oldest.Status, "deleted", oldest.DeletedAt != nil, "max_orders", s.maxOrders)
+	return true
+}
+
+// byNumber finds an order by its order number. Callers must hold s.mu.
+func (s *memoryStore) byNumber(number string) *Order {
+	for _, o := range s.orders {
+		if o.OrderNumber == number {
+			return o
+		}
+	}
+	return nil
+}
+
+// newOrderNumber returns a random public identifier not used by any stored
+// order. Callers must hold s.mu for writing.
+func (s *memoryStore) newOrderNumber() string {
+	for {
+		var b [8]byte
+		if _, err := rand.Read(b[:]); err != nil {
+			panic(err)
+		}
+		number := "ORD-" + orderNumberEncoding.EncodeToString(b[:])
+		if s.byNumber(number) == nil {
+			return number
+		}
+	}
+}
+
+var orderNumberEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// maxRandomID bounds random IDs to a signed 32-bit range, so they fit the
+// integer columns and JSON number handling clients commonly have.
+const maxRandomID = 1<<31 - 1
+
+// newRandomID picks an unused ID in [1, maxRandomID], retrying on a
+// collision. Callers must hold s.mu.
+func (s *memoryStore) newRandomID() int {
+	for {
+		var b [8]byte
+		if _, err := rand.Read(b[:]); err != nil {
+			panic(err)
+		}
+		id := int(binary.BigEndian.Uint64(b[:])%maxRandomID) + 1
+		if s.orders[id] == nil {
+			return id
+		}
+	}
+}
+
+// changed records a mutation. Callers must hold s.mu for writing.
+func (s *memoryStore) changed() {
+	s.lastModified = now()
+	s.persist()
+}
+
+// cloneOrder deep-copies o so that nothing reachable from the result is
+// shared with the original.
+func cloneOrder(o Order) Order {
+	if o.Items != nil {
+		o.Items = append([]LineItem(nil), o.Items...)
+	}
+	if o.Tags != nil {
+		o.Tags = append([]string(nil), o.Tags...)
+	}
+	if o.History != nil {
+		o.History = append([]OrderEvent(nil), o.History...)
+		for i, e := range o.History {
+			o.History[i].Notes = clonePtr(e.Notes)
+		}
+	}
+	o.CancelledAt = clonePtr(o.CancelledAt)
+	o.ProcessingAt = clonePtr(o.ProcessingAt)
+	o.ShippedAt = clonePtr(o.ShippedAt)
+	o.CompletedAt = clonePtr(o.CompletedAt)
+	o.DeletedAt = clonePtr(o.DeletedAt)
+	return o
+}
+
+func clonePtr[T any](p *T) *T {
+	if p == nil {
+		return nil
+	}
+	v := *p
+	return &v
+}
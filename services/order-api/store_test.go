@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCustomerActiveOrderLimit(t *testing.T) {
+	ctx := context.Background()
+	s := newMemoryStore("")
+	s.maxActivePerCustomer = 2
+	s.seed([]Order{
+		{ID: 1, CustomerID: 7, Status: statusCompleted},
+		{ID: 2, CustomerID: 7, Status: statusPending},
+	})
+	order := Order{CustomerID: 7, ProductID: 1, Quantity: 1, Status: statusPending}
+
+	// One active order plus a completed one: below the limit.
+	if _, err := s.Create(ctx, order); err != nil {
+		t.Fatalf("create below the limit: %v", err)
+	}
+	// Two active orders: at the limit.
+	if _, err := s.Create(ctx, order); !errors.Is(err, errCustomerLimit) {
+		t.Fatalf("create at the limit = %v, want errCustomerLimit", err)
+	}
+	other := order
+	other.CustomerID = 8
+	if _, err := s.Create(ctx, other); err != nil {
+		t.Fatalf("another customer's create: %v", err)
+	}
+}
+
+func TestCustomerLimitReturns409(t *testing.T) {
+	useTestStore(t).maxActivePerCustomer = 1
+
+	body := `{"customer_id":7,"product_id":1,"quantity":1}`
+	rec := httptest.NewRecorder()
+	createOrder(rec, jsonRequest("POST", "/api/orders", body))
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("first create: status = %d, body %s", rec.Code, rec.Body)
+	}
+	rec = httptest.NewRecorder()
+	createOrder(rec, jsonRequest("POST", "/api/orders", body))
+	if rec.Code != http.StatusConflict || !strings.Contains(rec.Body.String(), `"code":"customer_limit_reached"`) {
+		t.Fatalf("create over the limit: status = %d, body %s", rec.Code, rec.Body)
+	}
+}
+
+func TestConcurrentCreatesGetUniqueIDs(t *testing.T) {
+	const workers, perWorker = 50, 20
+	ctx := context.Background()
+	s := newMemoryStore("")
+
+	ids := make(chan int, workers*perWorker)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < perWorker; i++ {
+				o, err := s.Create(ctx, Order{CustomerID: 1, ProductID: 1, Quantity: 1, Status: statusPending})
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				ids <- o.ID
+			}
+		}()
+	}
+	wg.Wait()
+	close(ids)
+
+	seen := make(map[int]bool)
+	for id := range ids {
+		if seen[id] {
+			t.Fatalf("ID %d handed out twice", id)
+		}
+		seen[id] = true
+	}
+	if len(seen) != workers*perWorker {
+		t.Fatalf("got %d IDs, want %d", len(seen), workers*perWorker)
+	}
+}
+
+// Run with -race: readers scribble on their copies while writers update the
+// stored order, which only stays quiet if the store hands out deep copies.
+func TestConcurrentReadsAndUpdates(t *testing.T) {
+	ctx := context.Background()
+	s := newMemoryStore("")
+	notes := "first"
+	s.seed([]Order{{
+		ID: 1, CustomerID: 1, Status: statusPending,
+		Items:   []LineItem{{ProductID: 1, Quantity: 1}},
+		Tags:    []string{"a"},
+		History: []OrderEvent{{To: statusPending, Notes: &notes}},
+	}})
+
+	var wg sync.WaitGroup
+	for w := 0; w < 8; w++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				o, err := s.Get(ctx, 1)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				o.Items[0].Quantity++
+				o.Tags[0] = "mine"
+				*o.History[0].Notes = "mine"
+				if list, err := s.List(ctx); err == nil && len(list) == 1 {
+					list[0].Tags[0] = "mine"
+				}
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				_, err := s.Update(ctx, 1, func(o *Order) error {
+					o.Items[0].Quantity++
+					o.Tags[0] = "b"
+					*o.History[0].Notes = "updated"
+					o.History = append(o.History, OrderEvent{To: o.Status})
+					o.Version++
+					return nil
+				})
+				if err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	o, err := s.Get(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if o.Items[0].Quantity != 1+8*200 || o.Tags[0] != "b" || *o.History[0].Notes != "updated" {
+		t.Fatalf("a reader's change leaked into the store: %+v", o)
+	}
+}
+
+func TestEvictionTakesSoftDeletedFirst(t *testing.T) {
+	ctx := context.Background()
+	s := newMemoryStore("")
+	s.maxOrders, s.evict = 2, true
+	deletedAt := now()
+	s.seed([]Order{
+		{ID: 1, CustomerID: 1, Status: statusCompleted, CreatedAt: deletedAt.Add(-2 * time.Hour)},
+		{ID: 2, CustomerID: 1, Status: statusPending, CreatedAt: deletedAt.Add(-time.Hour), DeletedAt: &deletedAt},
+	})
+	order := Order{CustomerID: 1, ProductID: 1, Quantity: 1, Status: statusPending}
+
+	if _, err := s.Create(ctx, order); err != nil {
+		t.Fatalf("create over a soft-deleted order: %v", err)
+	}
+	if _, err := s.Get(ctx, 2); !errors.Is(err, errOrderNotFound) {
+		t.Fatalf("soft-deleted order survived eviction: %v", err)
+	}
+	if _, err := s.Create(ctx, order); err != nil {
+		t.Fatalf("create over a completed order: %v", err)
+	}
+	if _, err := s.Get(ctx, 1); !errors.Is(err, errOrderNotFound) {
+		t.Fatalf("completed order survived eviction: %v", err)
+	}
+	if _, err := s.Create(ctx, order); !errors.Is(err, errStoreFull) {
+		t.Fatalf("create with only active orders = %v, want errStoreFull", err)
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"sync/atomic"
+)
+
+// Tags are short free-form labels; these bounds keep them from turning into
+// a second notes field.
+const (
+	maxTags      = 20
+	maxTagLength = 50
+)
+
+// normalizeTag trims and lowercases a tag so "Gift " and "gift" are one tag.
+func normalizeTag(tag string) string {
+	return strings.ToLower(strings.TrimSpace(tag))
+}
+
+// normalizeTags normalizes and dedupes tags, keeping first-seen order, and
+// rejects empty or overlong ones.
+func normalizeTags(tags []string) ([]string, error) {
+	if len(tags) == 0 {
+		return nil, nil
+	}
+	out := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		tag = normalizeTag(tag)
+		if tag == "" {
+			return nil, errors.New("Invalid tag: must not be empty")
+		}
+		if len(tag) > maxTagLength {
+			return nil, fmt.Errorf("Invalid tag %q: longer than %d characters", tag, maxTagLength)
+		}
+		if !slices.Contains(out, tag) {
+			out = append(out, tag)
+		}
+	}
+	if len(out) > maxTags {
+		return nil, fmt.Errorf("Too many tags: %d, max %d", len(out), maxTags)
+	}
+	return out, nil
+}
+
+// tagsHandler adds the listed tags to an order on POST and removes them on
+// DELETE. Adding a tag it has or removing one it lacks is not an error.
+func tagsHandler(w http.ResponseWriter, r *http.Request, id int) {
+	if r.Method != "POST" && r.Method != "DELETE" {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	var req struct {
+		Tags []string `json:"tags"`
+	}
+	if !decodeBody(w, r, &req) {
+		return
+	}
+	tags, err := normalizeTags(req.Tags)
+	if err != nil {
+		writeError(w, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+	if len(tags) == 0 {
+		writeError(w, http.StatusUnprocessableEntity, "Invalid request")
+		return
+	}
+
+	order, err := store.Update(r.Context(), id, func(order *Order) error {
+		if order.DeletedAt != nil {
+			return errOrderDeleted
+		}
+		if r.Method == "POST" {
+			next, err := normalizeTags(append(order.Tags, tags...))
+			if err != nil {
+				return &statusError{http.StatusUnprocessableEntity, err.Error()}
+			}
+			order.Tags = next
+		} else {
+			order.Tags = slices.DeleteFunc(order.Tags, func(t string) bool { return slices.Contains(tags, t) })
+			if len(order.Tags) == 0 {
+				order.Tags = nil
+			}
+		}
+		order.Version++
+		return nil
+	})
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	notifyWebhook(eventOrderUpdated, order)
+	atomic.AddUint64(&ordersUpdatedTotal, 1)
+
+	logEvent(r, "debug", "Order tags changed", "order_id", id, "tags", strings.Join(order.Tags, ","))
+	json.NewEncoder(w).Encode(Response{Success: true, Data: order})
+}
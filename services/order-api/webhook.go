@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+const (
+	eventOrderCreated   = "order.created"
+	eventOrderUpdated   = "order.updated"
+	eventOrderDeleted   = "order.deleted"
+	eventOrderCancelled = "order.cancelled"
+	eventOrderExpired   = "order.expired"
+)
+
+const webhookAttempts = 3
+
+// Deliveries are made by a fixed set of workers from a bounded queue, so a
+// slow or dead receiver costs at most webhookQueueSize pending events rather
+// than a goroutine per event.
+const (
+	webhookWorkers   = 4
+	webhookQueueSize = 1000
+)
+
+var (
+	webhookURL    string
+	webhookClient = &http.Client{Timeout: 5 * time.Second}
+	webhookQueue  chan webhookEvent
+)
+
+type webhookEvent struct {
+	Event     string    `json:"event"`
+	Order     Order     `json:"order"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// updateEvent is the event for an update that moved o from status from to
+// its current status: order.cancelled when it was just cancelled, however
+// that happened, and order.updated otherwise.
+func updateEvent(from string, o Order) string {
+	if o.Status == statusCancelled && from != statusCancelled {
+		return eventOrderCancelled
+	}
+	return eventOrderUpdated
+}
+
+// startWebhooks starts the delivery workers for url; with no url, events
+// are not sent.
+func startWebhooks(url string) {
+	webhookURL = url
+	if url == "" {
+		return
+	}
+	queue := make(chan webhookEvent, webhookQueueSize)
+	webhookQueue = queue
+	for i := 0; i < webhookWorkers; i++ {
+		go func() {
+			for e := range queue {
+				deliverWebhook(e)
+			}
+		}()
+	}
+}
+
+// notifyWebhook queues event for delivery so the request that caused it
+// never waits on the receiver. When the queue is full the event is dropped.
+func notifyWebhook(event string, order Order) {
+	if webhookQueue == nil {
+		return
+	}
+	select {
+	case webhookQueue <- webhookEvent{Event: event, Order: order, Timestamp: now()}:
+	default:
+		logEvent(nil, "error", "Dropping webhook, queue full", "event", event, "order_id", order.ID, "queue_size", webhookQueueSize)
+	}
+}
+
+func deliverWebhook(e webhookEvent) {
+	body, err := json.Marshal(e)
+	if err != nil {
+		logEvent(nil, "error", "Failed to encode webhook", "event", e.Event, "order_id", e.Order.ID, "error", err.Error())
+		return
+	}
+
+	backoff := 500 * time.Millisecond
+	for attempt := 1; ; attempt++ {
+		err = postWebhook(body)
+		if err == nil {
+			return
+		}
+		if attempt == webhookAttempts {
+			break
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+	logEvent(nil, "error", "Giving up on webhook", "event", e.Event, "order_id", e.Order.ID, "attempts", webhookAttempts, "error", err.Error())
+}
+
+func postWebhook(body []byte) error {
+	resp, err := webhookClient.Post(webhookURL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestWebhookDelivery(t *testing.T) {
+	got := make(chan webhookEvent, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var e webhookEvent
+		json.NewDecoder(r.Body).Decode(&e)
+		got <- e
+	}))
+	defer srv.Close()
+	startWebhooks(srv.URL)
+	t.Cleanup(func() {
+		close(webhookQueue)
+		webhookQueue, webhookURL = nil, ""
+	})
+
+	notifyWebhook(eventOrderCreated, Order{ID: 7})
+	select {
+	case e := <-got:
+		if e.Event != eventOrderCreated || e.Order.ID != 7 {
+			t.Fatalf("delivered %+v", e)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("webhook not delivered")
+	}
+}
+
+func TestWebhookDropsWhenQueueFull(t *testing.T) {
+	// No workers drain this queue, so it stays full after one event.
+	webhookQueue = make(chan webhookEvent, 1)
+	t.Cleanup(func() { webhookQueue = nil })
+
+	notifyWebhook(eventOrderCreated, Order{ID: 1})
+	done := make(chan struct{})
+	go func() {
+		notifyWebhook(eventOrderCreated, Order{ID: 2})
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("notifyWebhook blocked on a full queue")
+	}
+	if e := <-webhookQueue; e.Order.ID != 1 || len(webhookQueue) != 0 {
+		t.Fatalf("queue held %+v and %d more", e, len(webhookQueue))
+	}
+}